@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// clipboardCommands are tried in order; the first one on PATH wins.
+var clipboardCommands = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// copyToClipboard puts text on the system clipboard using whatever helper
+// the platform has.
+func copyToClipboard(text string) error {
+	for _, argv := range clipboardCommands {
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return errors.New("no clipboard command found (pbcopy, wl-copy, xclip, xsel or clip.exe)")
+}
+
+// copyFileToClipboard puts the contents of the file at path on the
+// clipboard (-clip).
+func copyFileToClipboard(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return copyToClipboard(string(data))
+}
+
+// clipCopiedMsg reports the result of copySelection back to the TUI.
+type clipCopiedMsg struct {
+	st  buildStats
+	err error
+}
+
+// copySelection ("y") builds the markdown for the current selection in
+// memory and puts it on the clipboard, without writing anything to disk.
+// It runs as a command so the tree stays responsive meanwhile; whatever the
+// build needs from the tree is taken here, as the tree keeps changing.
+func (m model) copySelection() tea.Cmd {
+	base, selected, opts := m.base, dropGlobs(m.selectedFiles(), m.opts.exclude), m.opts
+	opts.verbose = false // stderr is under the TUI
+	if opts.dirListings {
+		opts.listings = collectDirListings(opts.tree, selected)
+	}
+	if opts.listExcluded {
+		opts.excluded = excludedFiles(opts.tree, selected)
+	}
+	opts.tree = nil
+	return func() tea.Msg {
+		st, err := copyMarkdown(base, selected, opts)
+		return clipCopiedMsg{st, err}
+	}
+}
+
+// copyMarkdown is build with the clipboard as the destination. The
+// -redact-paths mapping is not printed.
+func copyMarkdown(base string, selectedRelSlash []string, opts buildOptions) (buildStats, error) {
+	if opts.atRef != "" {
+		dir, kept, err := materializeAt(base, opts.atRef, selectedRelSlash)
+		if err != nil {
+			return buildStats{}, err
+		}
+		defer os.RemoveAll(dir)
+		base, selectedRelSlash = dir, kept
+	}
+	if opts.redactPaths {
+		opts.redact = newPathRedactor()
+	}
+
+	var buf bytes.Buffer
+	st, err := buildMarkdown(&buf, base, selectedRelSlash, opts)
+	if err != nil {
+		return buildStats{}, err
+	}
+	return st, copyToClipboard(buf.String())
+}
+
+// clipFlash is the status line note for a finished copySelection.
+func clipFlash(msg clipCopiedMsg) string {
+	if msg.err != nil {
+		return "copy failed: " + unwrapPathError(msg.err).Error()
+	}
+	return fmt.Sprintf("copied %s, %s tok", humanBytes(msg.st.size), humanCount(msg.st.tokens))
+}
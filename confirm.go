@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Enter doesn't build right away: it first shows what would be built (files,
+// size, estimated tokens) and a second enter confirms, esc goes back.
+
+var confirmBack = key.NewBinding(
+	key.WithKeys("esc"),
+	key.WithHelp("esc", "back"),
+)
+
+// confirmHelp is the footer help shown on the confirmation screen.
+type confirmHelp struct{ k keyMap }
+
+func (h confirmHelp) ShortHelp() []key.Binding {
+	return []key.Binding{h.k.Confirm, confirmBack}
+}
+
+func (h confirmHelp) FullHelp() [][]key.Binding {
+	return [][]key.Binding{h.ShortHelp()}
+}
+
+func (m model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Confirm):
+		m.confirmed = true
+		return m, tea.Quit
+	case key.Matches(msg, confirmBack):
+		m.confirming = false
+	}
+	return m, nil
+}
+
+func (m model) viewConfirm() string {
+	// What -exclude leaves out at build time isn't shown or counted.
+	var nodes []*node
+	var size int64
+	var estimate float64
+	for _, n := range m.orderedSelection() {
+		relSlash := filepath.ToSlash(n.relBase)
+		if matchAnyGlob(m.opts.exclude, relSlash) {
+			continue
+		}
+		nodes = append(nodes, n)
+		size += n.size
+		estimate += float64(n.size) / m.opts.ratios.forFile(relSlash)
+	}
+	tokens := humanCount(int64(math.Ceil(estimate)))
+	if m.maxTokens > 0 {
+		tokens += "/" + humanCount(m.maxTokens)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "build? | %d files | ~%s | ~%s tok\n", len(nodes), humanBytes(size), tokens)
+
+	// Leave room for the header, the "more" line and the help footer.
+	shown := min(len(nodes), max(m.viewportHeight()-1, 1))
+	for _, n := range nodes[:shown] {
+		fmt.Fprintf(&b, "  %s  %s\n", filepath.ToSlash(n.relBase), humanBytes(n.size))
+	}
+	if shown < len(nodes) {
+		fmt.Fprintf(&b, "  ... and %d more\n", len(nodes)-shown)
+	}
+
+	b.WriteString(m.help.View(confirmHelp{m.keys}))
+	return b.String()
+}
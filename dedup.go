@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// findDuplicates maps each selected file whose content is identical to an
+// earlier one in the selection to that first file (-dedup). Empty files are
+// left alone: pointing from one to another saves nothing.
+//
+// Whether a file is a duplicate has to be known before its header is
+// written, so this can't hash as a side effect of embedding; it is a pass of
+// its own, but only files sharing their size with another one are read.
+func findDuplicates(base string, selectedRelSlash []string) (map[string]string, error) {
+	sizes := make(map[int64]int, len(selectedRelSlash))
+	size := make([]int64, len(selectedRelSlash))
+	for i, relSlash := range selectedRelSlash {
+		st, err := os.Stat(filepath.Join(base, filepath.FromSlash(relSlash)))
+		if err != nil {
+			return nil, err
+		}
+		size[i] = st.Size()
+		sizes[size[i]]++
+	}
+
+	first := make(map[string]string)
+	dups := make(map[string]string)
+	for i, relSlash := range selectedRelSlash {
+		if size[i] == 0 || sizes[size[i]] < 2 {
+			continue
+		}
+		sum, err := hashFile(filepath.Join(base, filepath.FromSlash(relSlash)))
+		if err != nil {
+			return nil, err
+		}
+		if orig, ok := first[sum]; ok {
+			dups[relSlash] = orig
+			continue
+		}
+		first[sum] = relSlash
+	}
+	return dups, nil
+}
+
+// writeDuplicateSection is the section of a file whose content was already
+// embedded under orig: just the header and a pointer.
+func writeDuplicateSection(w io.Writer, title, orig string, opts buildOptions) {
+	sectionStart(w, title, opts)
+	fmt.Fprintf(w, "> Same content as `%s`, not repeated.\n\n", displayPath(orig, opts))
+	sectionEnd(w, opts)
+}
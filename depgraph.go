@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goModule is a go.mod found above selected Go files.
+type goModule struct {
+	dirSlash string // base-relative directory of go.mod
+	path     string // module path
+}
+
+// findGoModule walks up from the base-relative slash dir to base looking for
+// a go.mod. ok is false if there is none.
+func findGoModule(base, dirSlash string, cache map[string]*goModule) (*goModule, bool) {
+	var visited []string
+	dir := dirSlash
+	for {
+		if mod, ok := cache[dir]; ok {
+			for _, v := range visited {
+				cache[v] = mod
+			}
+			return mod, mod != nil
+		}
+		visited = append(visited, dir)
+
+		if modPath := readModulePath(filepath.Join(base, filepath.FromSlash(dir), "go.mod")); modPath != "" {
+			mod := &goModule{dirSlash: dir, path: modPath}
+			for _, v := range visited {
+				cache[v] = mod
+			}
+			return mod, true
+		}
+		if dir == "." {
+			for _, v := range visited {
+				cache[v] = nil
+			}
+			return nil, false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// importPath is the import path of the package in the base-relative slash
+// dir, which must be inside the module.
+func (mod *goModule) importPath(dirSlash string) string {
+	switch {
+	case dirSlash == mod.dirSlash:
+		return mod.path
+	case mod.dirSlash == ".":
+		return path.Join(mod.path, dirSlash)
+	default:
+		return path.Join(mod.path, strings.TrimPrefix(dirSlash, mod.dirSlash+"/"))
+	}
+}
+
+// dirOf is the inverse of importPath; ok is false for paths outside the
+// module.
+func (mod *goModule) dirOf(importPath string) (string, bool) {
+	if importPath == mod.path {
+		return mod.dirSlash, true
+	}
+	rest, ok := strings.CutPrefix(importPath, mod.path+"/")
+	if !ok {
+		return "", false
+	}
+	return path.Join(mod.dirSlash, rest), true
+}
+
+func readModulePath(goModPath string) string {
+	f, err := os.Open(goModPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if rest, ok := strings.CutPrefix(line, "module"); ok {
+			rest = strings.TrimSpace(rest)
+			if unq, err := strconv.Unquote(rest); err == nil {
+				rest = unq
+			}
+			return rest
+		}
+	}
+	return ""
+}
+
+// goImportGraph maps the import path of every package among the selected Go
+// files to the selected packages it imports.
+//
+// dirs maps each package to its base-relative slash directory.
+func goImportGraph(base string, selectedRelSlash []string) (pkgs []string, edges map[string][]string, dirs map[string]string) {
+	mods := make(map[string]*goModule)
+	dirs = make(map[string]string)
+	imports := make(map[string]map[string]bool) // pkg -> imported paths
+	for _, relSlash := range selectedRelSlash {
+		if path.Ext(relSlash) != ".go" {
+			continue
+		}
+		dir := path.Dir(relSlash)
+		mod, ok := findGoModule(base, dir, mods)
+		if !ok {
+			continue
+		}
+		pkg := mod.importPath(dir)
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, filepath.Join(base, filepath.FromSlash(relSlash)), nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		if imports[pkg] == nil {
+			imports[pkg] = make(map[string]bool)
+			dirs[pkg] = dir
+		}
+		for _, imp := range f.Imports {
+			p, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				continue
+			}
+			imports[pkg][p] = true
+		}
+	}
+
+	edges = make(map[string][]string)
+	for pkg, imps := range imports {
+		pkgs = append(pkgs, pkg)
+		for imp := range imps {
+			if _, ok := imports[imp]; ok && imp != pkg {
+				edges[pkg] = append(edges[pkg], imp)
+			}
+		}
+		sort.Strings(edges[pkg])
+	}
+	sort.Strings(pkgs)
+	return pkgs, edges, dirs
+}
+
+// writeDepGraph emits a Mermaid diagram of imports between the selected Go
+// packages. Nothing is written if there are no Go files. With a redactor,
+// packages are labeled by their redacted directory instead of import path.
+func writeDepGraph(w io.Writer, base string, selectedRelSlash []string, redact *pathRedactor) {
+	pkgs, edges, dirs := goImportGraph(base, selectedRelSlash)
+	if len(pkgs) == 0 {
+		return
+	}
+
+	ids := make(map[string]string, len(pkgs))
+	for i, p := range pkgs {
+		ids[p] = fmt.Sprintf("p%d", i)
+	}
+
+	fmt.Fprintf(w, "## Dependency graph\n\n")
+	fmt.Fprintln(w, "```mermaid")
+	fmt.Fprintln(w, "graph LR")
+	for _, p := range pkgs {
+		label := p
+		if redact != nil {
+			label = redact.dir(dirs[p])
+		}
+		fmt.Fprintf(w, "  %s[%q]\n", ids[p], label)
+	}
+	for _, p := range pkgs {
+		for _, imp := range edges[p] {
+			fmt.Fprintf(w, "  %s --> %s\n", ids[p], ids[imp])
+		}
+	}
+	fmt.Fprintln(w, "```")
+	fmt.Fprintln(w)
+}
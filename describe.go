@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDescription caps -describe lines; they are for orientation only.
+const maxDescription = 120
+
+// commentPrefixes are stripped from a leading comment line, longest first.
+var commentPrefixes = []string{"<!--", "///", "//!", "//", "/**", "/*", "--", "#", ";", "\"\"\""}
+
+// describeFile returns a one-line description of a file: for Go the package
+// clause and the first sentence of the package doc, otherwise the first
+// comment near the top or, failing that, the first non-blank line.
+func describeFile(abs string) string {
+	if _, ok := lfsNote(abs); ok {
+		return ""
+	}
+	if languageFor(abs) == "go" {
+		if d := describeGo(abs); d != "" {
+			return d
+		}
+	}
+
+	f, err := os.Open(abs)
+	if err != nil {
+		return "" // the file's section reports it
+	}
+	defer f.Close()
+
+	var first string
+	sc := bufio.NewScanner(f)
+	for i := 0; sc.Scan() && i < 20; i++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		for _, p := range commentPrefixes {
+			if rest, ok := strings.CutPrefix(line, p); ok {
+				rest = strings.TrimSpace(strings.TrimRight(rest, "-*/>\""))
+				if rest != "" {
+					return truncateDescription(rest)
+				}
+				break
+			}
+		}
+		if first == "" {
+			first = line
+		}
+	}
+	return truncateDescription(first)
+}
+
+// describeGo reads only the package clause and its doc comment.
+func describeGo(abs string) string {
+	f, err := parser.ParseFile(token.NewFileSet(), abs, nil, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+	d := "package " + f.Name.Name
+	if f.Doc != nil {
+		doc := strings.Join(strings.Fields(f.Doc.Text()), " ")
+		if i := strings.Index(doc, ". "); i >= 0 {
+			doc = doc[:i+1]
+		}
+		d += ": " + doc
+	}
+	return truncateDescription(d)
+}
+
+func truncateDescription(s string) string {
+	if utf8.RuneCountInString(s) <= maxDescription {
+		return s
+	}
+	return string([]rune(s)[:maxDescription-1]) + "…"
+}
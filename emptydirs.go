@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// findEmptyDirs returns base-relative slash paths of directories (fs mode)
+// that contain none of files, e.g. really empty ones or ones holding only
+// filtered-out binaries. Nested empty directories are all reported;
+// ignored ones (see gitignore) and those under a directory named in skip
+// (-no-vendor) are not.
+func findEmptyDirs(base string, files []string, skip map[string]bool) ([]string, error) {
+	nonEmpty := map[string]bool{".": true}
+	for _, relSlash := range files {
+		for d := path.Dir(relSlash); !nonEmpty[d]; d = path.Dir(d) {
+			nonEmpty[d] = true
+		}
+	}
+
+	var dirs []string
+	ign := newGitignore(base)
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		relSlash := filepath.ToSlash(rel)
+		if d.Name() == ".git" || (relSlash != "." && (skip[d.Name()] || ign.ignored(relSlash, true))) {
+			return fs.SkipDir
+		}
+		if !nonEmpty[relSlash] {
+			dirs = append(dirs, relSlash)
+		}
+		return ign.load(relSlash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// addEmptyDirs adds the given directories to a tree built by buildTree as
+// informational nodes.
+func addEmptyDirs(root *node, dirs []string) {
+	for _, relSlash := range dirs {
+		cur := root
+		for _, part := range strings.Split(relSlash, "/") {
+			child, ok := cur.child(part)
+			if !ok {
+				child = newDir(cur, part, filepath.Join(cur.relBase, filepath.FromSlash(part)))
+				child.empty = true
+				cur.addChild(child)
+			}
+			cur = child
+		}
+	}
+	finalizeTree(root)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// flattenFiltered lists the files whose path contains query
+// (case-insensitively), whose extension is ext (if not empty) and that pass
+// the git status filter, together with their ancestor directories,
+// regardless of which directories are expanded.
+func flattenFiltered(root *node, query, ext string, status statusFilter) []*node {
+	query = strings.ToLower(query)
+	var out []*node
+	var walk func(*node) bool
+	walk = func(n *node) bool {
+		if n.hidden {
+			return false
+		}
+		if !n.isDir {
+			if strings.Contains(strings.ToLower(filepath.ToSlash(n.relBase)), query) && (ext == "" || fileExt(n) == ext) && status.matches(n) {
+				out = append(out, n)
+				return true
+			}
+			return false
+		}
+		at := len(out)
+		out = append(out, n)
+		matched := false
+		for _, c := range n.children {
+			if walk(c) {
+				matched = true
+			}
+		}
+		if !matched && n != root {
+			out = out[:at]
+		}
+		return matched
+	}
+	walk(root)
+	return out
+}
+
+// visible is m.vis for the current tree state and filters.
+func (m model) visible() []*node {
+	if m.filter == "" && m.filterExt == "" && m.filterStatus == statusAll {
+		return flattenVisible(m.root)
+	}
+	return flattenFiltered(m.root, m.filter, m.filterExt, m.filterStatus)
+}
+
+// setFilter changes the query, keeping the cursor on the same node when it
+// is still visible.
+func (m *model) setFilter(query string) {
+	m.filter = query
+	m.refilter()
+}
+
+// cycleExtFilter ("t") steps the extension filter through the extensions
+// in the tree, most common first, and then back to none.
+func (m *model) cycleExtFilter() {
+	exts := treeExts(m.root)
+	next := ""
+	if i := slices.Index(exts, m.filterExt); i+1 < len(exts) {
+		next = exts[i+1] // from none (i == -1), the most common one
+	}
+	m.filterExt = next
+	m.refilter()
+}
+
+// cycleStatusFilter ("g") steps the git status filter through all,
+// modified, untracked and staged. Outside a repository there is no status
+// to filter by. git status runs the first time, as it can take a while in
+// big repositories; if it fails, the filter stays off.
+func (m *model) cycleStatusFilter() {
+	if !m.inRepo {
+		return
+	}
+	if !m.gitStatusSet {
+		states, err := gitStatusFiles(m.base)
+		if err != nil {
+			m.flash = err.Error()
+			return
+		}
+		attachGitStatus(m.root, states)
+		m.gitStatusSet = true
+	}
+	m.filterStatus = (m.filterStatus + 1) % numStatusFilters
+	m.refilter()
+}
+
+// refilter recomputes m.vis after a filter change, keeping the cursor on the
+// same node when it is still visible.
+func (m *model) refilter() {
+	old := m.vis[m.cursor]
+	m.vis = m.visible()
+	m.cursor = indexOf(m.vis, old)
+	m.ensureCursorVisible()
+}
+
+// fileExt is the lowercased extension of n's name, with the dot.
+func fileExt(n *node) string {
+	return strings.ToLower(filepath.Ext(n.name))
+}
+
+// treeExts returns the file extensions in the tree (hidden files aside),
+// most common first.
+func treeExts(root *node) []string {
+	counts := make(map[string]int)
+	var walk func(*node)
+	walk = func(n *node) {
+		if n.hidden {
+			return
+		}
+		if !n.isDir {
+			if ext := fileExt(n); ext != "" {
+				counts[ext]++
+			}
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	exts := make([]string, 0, len(counts))
+	for ext := range counts {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if counts[exts[i]] != counts[exts[j]] {
+			return counts[exts[i]] > counts[exts[j]]
+		}
+		return exts[i] < exts[j]
+	})
+	return exts
+}
+
+// updateFilter edits the query while typing it. Keys it doesn't use (e.g.
+// arrows) are left to the tree, so handled is false for them.
+func (m model) updateFilter(msg tea.KeyMsg) (_ model, handled bool) {
+	switch msg.Type {
+	case tea.KeyRunes, tea.KeySpace:
+		m.setFilter(m.filter + string(msg.Runes))
+	case tea.KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.setFilter(string(r[:len(r)-1]))
+		}
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyEsc:
+		m.filtering = false
+		m.setFilter("")
+	default:
+		return m, false
+	}
+	return m, true
+}
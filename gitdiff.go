@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diffStat is a file's `git diff --numstat` line counts.
+type diffStat struct {
+	added, deleted int
+	binary         bool // numstat has no line counts for binary files
+}
+
+// gitChangedFiles returns the files changed in the given revision range
+// (anything `git diff` accepts, e.g. "HEAD~3..HEAD", or a single ref to
+// compare with the working tree), by base-relative slash path. Files
+// deleted in the range are left out since there is nothing to embed.
+func gitChangedFiles(base string, revRange string) (map[string]diffStat, error) {
+	cmd := exec.Command("git",
+		"-C", base,
+		"-c", "core.quotePath=false",
+		"diff",
+		"--numstat",
+		"-z",
+		"--no-renames",
+		"--diff-filter=d",
+		revRange,
+		"--",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s: %s", revRange, strings.TrimSpace(stderr.String()))
+	}
+
+	// With -z and no renames, every entry is "added\tdeleted\tpath\0".
+	files := make(map[string]diffStat)
+	for _, p := range bytes.Split(out, []byte{0}) {
+		fields := strings.SplitN(string(p), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		var st diffStat
+		if fields[0] == "-" {
+			st.binary = true
+		} else {
+			st.added, _ = strconv.Atoi(fields[0])
+			st.deleted, _ = strconv.Atoi(fields[1])
+		}
+		files[fields[2]] = st
+	}
+	return files, nil
+}
+
+// attachDiffStats puts each file's counts on its tree node for the TUI.
+func attachDiffStats(root *node, stats map[string]diffStat) {
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			if st, ok := stats[filepath.ToSlash(n.relBase)]; ok {
+				n.diff = &st
+			}
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+}
+
+var (
+	diffAddedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	diffDeletedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// diffSuffix is the "+12 -3" note of a file row in -diff/-last-commits mode.
+func diffSuffix(n *node) string {
+	switch {
+	case n.diff == nil:
+		return ""
+	case n.diff.binary:
+		return "  (binary change)"
+	}
+	return "  " + diffAddedStyle.Render(fmt.Sprintf("+%d", n.diff.added)) + " " + diffDeletedStyle.Render(fmt.Sprintf("-%d", n.diff.deleted))
+}
+
+// keepOnly filters files down to those present in keep.
+func keepOnly(files, keep []string) []string {
+	set := make(map[string]bool, len(keep))
+	for _, k := range keep {
+		set[k] = true
+	}
+	out := files[:0]
+	for _, f := range files {
+		if set[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// gitVerifyCommit fails unless ref names a commit.
+func gitVerifyCommit(base, ref string) error {
+	cmd := exec.Command("git", "-C", base, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%q is not a commit", ref)
+	}
+	return nil
+}
+
+// materializeAt writes the ref's version of each selected file into a fresh
+// temporary directory, keeping base-relative paths, so the rest of the build
+// can read it like a working tree. Files missing at ref are reported on
+// stderr and dropped. The go.mod files above selected Go files come along
+// too (for -depgraph), without being selected. The caller removes dir.
+func materializeAt(base, ref string, selectedRelSlash []string) (dir string, kept []string, err error) {
+	dir, err = os.MkdirTemp("", "mkctx-at-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	goDirs := make(map[string]bool)
+	for _, relSlash := range selectedRelSlash {
+		ok, err := writeBlobAt(base, ref, relSlash, dir)
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "mkctx: skipping %s: not present at %s\n", relSlash, ref)
+			continue
+		}
+		kept = append(kept, relSlash)
+		if strings.HasSuffix(relSlash, ".go") {
+			for d := path.Dir(relSlash); !goDirs[d]; d = path.Dir(d) {
+				goDirs[d] = true
+				if d == "." {
+					break
+				}
+			}
+		}
+	}
+	for d := range goDirs {
+		modSlash := path.Join(d, "go.mod")
+		if slices.Contains(selectedRelSlash, modSlash) {
+			continue
+		}
+		if _, err := writeBlobAt(base, ref, modSlash, dir); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+	}
+	return dir, kept, nil
+}
+
+// writeBlobAt writes the ref's version of the base-relative slash path under
+// dir. ok is false if the path isn't present at ref.
+func writeBlobAt(base, ref, relSlash, dir string) (ok bool, err error) {
+	out, err := exec.Command("git", "-C", base, "cat-file", "blob", ref+":"+relSlash).Output()
+	if err != nil {
+		return false, nil
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(relSlash))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(dst, out, 0o644)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitignore is a small .gitignore matcher for fs mode, so walking a plain
+// directory skips what git would. Rules are loaded per directory while
+// descending; the last matching rule of the path's ancestors wins, as in
+// git. Not supported: escaped trailing spaces and core.excludesFile.
+type gitignore struct {
+	base  string
+	rules map[string][]ignoreRule // by base-relative slash directory
+}
+
+type ignoreRule struct {
+	pattern string // relative to the .gitignore's directory, for matchGlob
+	negate  bool   // "!pattern"
+	dirOnly bool   // "pattern/"
+}
+
+func newGitignore(base string) *gitignore {
+	return &gitignore{base: base, rules: make(map[string][]ignoreRule)}
+}
+
+// load reads relDir's .gitignore, if any. Call it for a directory before
+// asking about its entries.
+func (g *gitignore) load(relDir string) error {
+	f, err := os.Open(filepath.Join(g.base, filepath.FromSlash(relDir), ".gitignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseIgnoreLine(sc.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		g.rules[relDir] = rules
+	}
+	return nil
+}
+
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+	var r ignoreRule
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' {
+		line = line[1:] // "\#" and "\!"
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	// A slash at the start or in the middle anchors the pattern to the
+	// .gitignore's directory; otherwise it matches at any depth.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	r.pattern = line
+	return r, true
+}
+
+// ignored reports whether the base-relative slash path is ignored.
+func (g *gitignore) ignored(relSlash string, isDir bool) bool {
+	if len(g.rules) == 0 {
+		return false
+	}
+	var dirs []string
+	for d := path.Dir(relSlash); ; d = path.Dir(d) {
+		dirs = append(dirs, d)
+		if d == "." {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel := relSlash
+		if dirs[i] != "." {
+			rel = strings.TrimPrefix(relSlash, dirs[i]+"/")
+		}
+		for _, r := range g.rules[dirs[i]] {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			if matchGlob(r.pattern, rel) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
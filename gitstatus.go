@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitState is what `git status` says about a file; a file can be both
+// staged and modified again since.
+type gitState uint8
+
+const (
+	gitStaged gitState = 1 << iota
+	gitModified
+	gitUntracked
+)
+
+// gitStatusFiles returns the state of every file `git status` reports, by
+// base-relative slash path (base is the repository root).
+func gitStatusFiles(base string) (map[string]gitState, error) {
+	cmd := exec.Command("git",
+		"-C", base,
+		"-c", "core.quotePath=false",
+		"status",
+		"--porcelain",
+		"-z",
+		"--no-renames",
+		"--untracked-files=all",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	// With -z and no renames, every entry is "XY path\0": X is the index
+	// side, Y the working tree.
+	files := make(map[string]gitState)
+	for _, p := range bytes.Split(out, []byte{0}) {
+		if len(p) < 4 {
+			continue
+		}
+		x, y, relSlash := p[0], p[1], string(p[3:])
+		var st gitState
+		if x == '?' {
+			st = gitUntracked
+		} else {
+			if x != ' ' {
+				st |= gitStaged
+			}
+			if y != ' ' {
+				st |= gitModified
+			}
+		}
+		files[relSlash] = st
+	}
+	return files, nil
+}
+
+// attachGitStatus puts each file's state on its tree node for the TUI.
+func attachGitStatus(root *node, states map[string]gitState) {
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			n.git = states[filepath.ToSlash(n.relBase)]
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+}
+
+// statusFilter is the git status filter cycled with "g".
+type statusFilter int
+
+const (
+	statusAll statusFilter = iota
+	statusModified
+	statusUntracked
+	statusStaged
+	numStatusFilters
+)
+
+var statusFilterNames = [...]string{"all", "modified", "untracked", "staged"}
+
+func (f statusFilter) String() string { return statusFilterNames[f] }
+
+// matches reports whether file n passes the filter.
+func (f statusFilter) matches(n *node) bool {
+	switch f {
+	case statusModified:
+		return n.git&gitModified != 0
+	case statusUntracked:
+		return n.git&gitUntracked != 0
+	case statusStaged:
+		return n.git&gitStaged != 0
+	}
+	return true
+}
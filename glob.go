@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// parseGlobs splits a comma-separated -select value and checks each pattern.
+func parseGlobs(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no patterns")
+	}
+	return patterns, nil
+}
+
+// matchGlob reports whether the slash path name matches pattern: path.Match
+// per segment, plus "**" as a segment matching any number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectGlobs marks the tree's files matching any of patterns and returns
+// them in the given -order.
+func selectGlobs(root *node, patterns []string, order string) []string {
+	var picked []*node
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir && matchAnyGlob(patterns, filepath.ToSlash(n.relBase)) {
+			n.selected = true
+			picked = append(picked, n)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	sortNodes(picked, order)
+	out := make([]string, len(picked))
+	for i, n := range picked {
+		out[i] = filepath.ToSlash(n.relBase)
+	}
+	return out
+}
+
+// dropGlobs removes files matching any of patterns.
+func dropGlobs(files, patterns []string) []string {
+	kept := files[:0:0]
+	for _, relSlash := range files {
+		if !matchAnyGlob(patterns, relSlash) {
+			kept = append(kept, relSlash)
+		}
+	}
+	return kept
+}
+
+// excludeSelected applies -exclude to a finished selection, right before it
+// is built: the files stay in the tree, they just don't make it into the
+// output.
+func excludeSelected(selected []string, opts buildOptions) []string {
+	if len(opts.exclude) == 0 {
+		return selected
+	}
+	kept := dropGlobs(selected, opts.exclude)
+	if n := len(selected) - len(kept); n > 0 {
+		fmt.Fprintf(os.Stderr, "mkctx: -exclude: left out %d selected files\n", n)
+	}
+	return kept
+}
+
+// recursePattern turns a -recurse directory (relative to the working
+// directory) into a pattern matching every file under it.
+func recursePattern(base, dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if st, err := os.Stat(abs); err != nil {
+		return "", err
+	} else if !st.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	rel, err := filepath.Rel(base, abs)
+	if err != nil {
+		return "", err
+	}
+	relSlash := filepath.ToSlash(rel)
+	if relSlash == ".." || strings.HasPrefix(relSlash, "../") {
+		return "", fmt.Errorf("%s is outside %s", dir, base)
+	}
+	if relSlash == "." {
+		return "**", nil
+	}
+	return escapeGlob(relSlash) + "/**", nil
+}
+
+// escapeGlob quotes the characters path.Match treats specially.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// grepFiles keeps the files with at least minCount matches of re.
+func grepFiles(base string, files []string, re *regexp.Regexp, minCount int) []string {
+	var kept []string
+	for _, relSlash := range files {
+		if countMatches(filepath.Join(base, filepath.FromSlash(relSlash)), re, minCount) >= minCount {
+			kept = append(kept, relSlash)
+		}
+	}
+	return kept
+}
+
+// countMatches counts matches of re line by line, stopping once limit is
+// reached. Matches never span lines. Unreadable files count as zero.
+func countMatches(abs string, re *regexp.Regexp, limit int) int {
+	f, err := os.Open(abs)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	n := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() && n < limit {
+		n += len(re.FindAllIndex(sc.Bytes(), limit-n))
+	}
+	return n
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// shebangLanguages maps interpreters named in a #! line to fence languages.
+var shebangLanguages = map[string]string{
+	"python":  "python",
+	"python2": "python",
+	"python3": "python",
+	"bash":    "bash",
+	"sh":      "sh",
+	"zsh":     "zsh",
+	"node":    "javascript",
+	"ruby":    "ruby",
+	"perl":    "perl",
+}
+
+// shebangLanguage reads just the first line of the file and returns the fence
+// language for its #! interpreter (also through `env`), or "".
+func shebangLanguage(abs string) string {
+	f, err := os.Open(abs)
+	if err != nil {
+		return "" // embedding the file reports it
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(f, 256)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	rest, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return ""
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return ""
+	}
+	interp := path.Base(fields[0])
+	if interp == "env" {
+		// #!/usr/bin/env [-S] python3 -u
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interp = f
+				break
+			}
+		}
+	}
+	return shebangLanguages[interp]
+}
+
+// guessLanguage looks at the first few KB of a file with an unknown extension
+// and returns one of a handful of fence languages based on character
+// frequencies, or "" when nothing stands out.
+func guessLanguage(abs string) string {
+	f, err := os.Open(abs)
+	if err != nil {
+		return "" // embedding the file reports it
+	}
+	defer f.Close()
+
+	buf := make([]byte, 4096)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	b := bytes.TrimSpace(buf[:n])
+	if len(b) == 0 {
+		return ""
+	}
+
+	var freq [256]int
+	for _, c := range b {
+		freq[c]++
+	}
+	lines := bytes.Count(b, []byte{'\n'}) + 1
+	perLine := func(c byte) float64 { return float64(freq[c]) / float64(lines) }
+
+	switch {
+	case (b[0] == '{' || b[0] == '[') && freq['"'] >= 2 && freq[':'] >= 1:
+		return "json"
+	case b[0] == '<' && freq['<'] == freq['>'] && freq['<'] >= 2:
+		return "xml"
+	case perLine(',') >= 2 && freq['"']%2 == 0 && freq['{'] == 0:
+		return "csv"
+	case perLine('=') >= 0.5 && (b[0] == '[' || b[0] == '#' || b[0] == ';'):
+		return "ini"
+	case perLine(':') >= 0.5 && freq['{'] == 0 && freq[';'] == 0:
+		return "yaml"
+	default:
+		return ""
+	}
+}
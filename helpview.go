@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// viewHelpOverlay lists every binding of the tree view with its description,
+// one per line, grouped like FullHelp.
+func (m model) viewHelpOverlay() string {
+	groups := m.keys.FullHelp()
+
+	width := 0
+	for _, g := range groups {
+		for _, b := range g {
+			width = max(width, len([]rune(b.Help().Key)))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("mkctx keys\n\n")
+	for _, g := range groups {
+		for _, kb := range g {
+			writeHelpLine(&b, kb, width)
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString("0-9 before a move repeats it (5↓).\n")
+	b.WriteString("Press ? or esc to close.")
+	return b.String()
+}
+
+func writeHelpLine(b *strings.Builder, kb key.Binding, width int) {
+	h := kb.Help()
+	pad := width - len([]rune(h.Key))
+	fmt.Fprintf(b, "  %s%s  %s\n", h.Key, strings.Repeat(" ", pad), h.Desc)
+}
+
+// updateHelpOverlay closes the overlay on ? or esc and swallows other keys.
+func (m model) updateHelpOverlay(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if key.Matches(msg, m.keys.Help) || msg.Type == tea.KeyEsc {
+		m.showHelp = false
+	}
+	return m, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+// Hiding ("x") takes a file out of the tree for the rest of the session
+// without touching the selection logic: a hidden file is not selectable, so
+// it can't end up in the output. "X" brings all hidden files back.
+
+// hideCursor hides the file under the cursor, deselecting it (in parked
+// selection sets too) if needed. Directories are left alone.
+func (m *model) hideCursor() {
+	n := m.vis[m.cursor]
+	if n.isDir {
+		return
+	}
+	n.hidden = true
+	if n.selected {
+		n.selected = false
+		m.selectedCount--
+	}
+	for k, s := range m.sets {
+		kept := s.nodes[:0:0]
+		for _, sn := range s.nodes {
+			if sn != n {
+				kept = append(kept, sn)
+			}
+		}
+		s.nodes = kept
+		m.sets[k] = s
+	}
+	m.hiddenCount++
+
+	m.vis = m.visible()
+	m.ensureCursorVisible()
+}
+
+// unhideAll shows every hidden file again, keeping the cursor on its node.
+func (m *model) unhideAll() {
+	if m.hiddenCount == 0 {
+		return
+	}
+	var walk func(*node)
+	walk = func(n *node) {
+		n.hidden = false
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(m.root)
+	m.hiddenCount = 0
+
+	old := m.vis[m.cursor]
+	m.vis = m.visible()
+	m.cursor = indexOf(m.vis, old)
+	m.ensureCursorVisible()
+}
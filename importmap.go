@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsImportRe finds module specifiers in import/export-from statements,
+// side-effect imports, dynamic import() and require().
+var jsImportRe = regexp.MustCompile(`(?:\bfrom\s*|\bimport\s*\(?\s*|\brequire\s*\(\s*)['"]([^'"\n]+)['"]`)
+
+// jsExtensions are tried, in order, when resolving a relative JS/TS import.
+var jsExtensions = []string{".ts", ".tsx", ".js", ".jsx", ".mjs", ".cjs", ".d.ts"}
+
+// importRef is one import of a selected file: its spec as written (or a
+// redacted stand-in) and the selected files that satisfy it, if any.
+type importRef struct {
+	spec  string
+	files []string
+}
+
+// writeImportMap lists, for each selected Go or JS/TS file, the imports that
+// resolve to other selected files and the non-standard ones that don't, so
+// the reader knows what is and isn't in the context.
+func writeImportMap(w io.Writer, base string, selectedRelSlash []string, redact *pathRedactor) {
+	selected := make(map[string]bool, len(selectedRelSlash))
+	mods := make(map[string]*goModule)
+	goPkgFiles := make(map[string][]string) // import path -> selected files
+	for _, relSlash := range selectedRelSlash {
+		selected[relSlash] = true
+		if path.Ext(relSlash) != ".go" || strings.HasSuffix(relSlash, "_test.go") {
+			continue
+		}
+		dir := path.Dir(relSlash)
+		if mod, ok := findGoModule(base, dir, mods); ok {
+			pkg := mod.importPath(dir)
+			goPkgFiles[pkg] = append(goPkgFiles[pkg], relSlash)
+		}
+	}
+
+	var lines []string
+	for _, relSlash := range selectedRelSlash {
+		var refs []importRef
+		switch path.Ext(relSlash) {
+		case ".go":
+			refs = goImportRefs(base, relSlash, mods, goPkgFiles, redact)
+		case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+			refs = jsImportRefs(base, relSlash, selected, redact)
+		}
+		if len(refs) == 0 {
+			continue
+		}
+		lines = append(lines, redact.file(relSlash))
+		for _, r := range refs {
+			if len(r.files) == 0 {
+				lines = append(lines, "  "+r.spec+" (not included)")
+				continue
+			}
+			red := make([]string, len(r.files))
+			for i, f := range r.files {
+				red[i] = redact.file(f)
+			}
+			lines = append(lines, "  "+r.spec+" -> "+strings.Join(red, ", "))
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	maxRun := 0
+	for _, l := range lines {
+		maxRun = max(maxRun, maxRunByteInString(l, '`'))
+	}
+	fence := fenceForContent(maxRun)
+
+	fmt.Fprint(w, "## Import map\n\n")
+	fmt.Fprintln(w, fence+"text")
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	fmt.Fprintln(w, fence)
+	fmt.Fprintln(w)
+}
+
+// goImportRefs resolves a Go file's imports against the selected packages.
+// Standard library imports (no dot in the first element) are left out.
+func goImportRefs(base, relSlash string, mods map[string]*goModule, goPkgFiles map[string][]string, redact *pathRedactor) []importRef {
+	f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(base, filepath.FromSlash(relSlash)), nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	mod, _ := findGoModule(base, path.Dir(relSlash), mods)
+
+	var refs []importRef
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		first, _, _ := strings.Cut(p, "/")
+		if !strings.Contains(first, ".") && (mod == nil || p != mod.path && !strings.HasPrefix(p, mod.path+"/")) {
+			continue
+		}
+		spec := p
+		if redact != nil && mod != nil {
+			if dir, ok := mod.dirOf(p); ok {
+				spec = redact.dir(dir)
+			}
+		}
+		refs = append(refs, importRef{spec: spec, files: goPkgFiles[p]})
+	}
+	return refs
+}
+
+// jsImportRefs resolves a JS/TS file's relative imports against the selected
+// files. Package imports are left out.
+func jsImportRefs(base, relSlash string, selected map[string]bool, redact *pathRedactor) []importRef {
+	src, err := os.ReadFile(filepath.Join(base, filepath.FromSlash(relSlash)))
+	if err != nil {
+		return nil // like unparsable Go files; embedding it reports the error
+	}
+
+	seen := make(map[string]bool)
+	var refs []importRef
+	for _, m := range jsImportRe.FindAllSubmatch(src, -1) {
+		spec := string(m[1])
+		if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") || seen[spec] {
+			continue
+		}
+		seen[spec] = true
+
+		target := path.Join(path.Dir(relSlash), spec)
+		ref := importRef{spec: spec}
+		if redact != nil {
+			ref.spec = redact.file(target)
+		}
+		if resolved, ok := resolveJSImport(target, selected); ok {
+			ref.files = []string{resolved}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// resolveJSImport tries target as is, with the usual extensions, and as a
+// directory with an index file.
+func resolveJSImport(target string, selected map[string]bool) (string, bool) {
+	if selected[target] {
+		return target, true
+	}
+	for _, ext := range jsExtensions {
+		if selected[target+ext] {
+			return target + ext, true
+		}
+	}
+	for _, ext := range jsExtensions {
+		if idx := path.Join(target, "index"+ext); selected[idx] {
+			return idx, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lfsPointerPrefix starts every git-LFS pointer file; see
+// https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md.
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1\n"
+
+// lfsNote returns a one-line stand-in for a git-LFS pointer file, naming the
+// tracked object and its size, so the pointer text itself isn't embedded.
+func lfsNote(abs string) ([]byte, bool) {
+	st, err := os.Stat(abs)
+	if err != nil || st.Size() >= 1024 { // the spec caps pointers below 1024 bytes
+		return nil, false
+	}
+	src, err := os.ReadFile(abs)
+	if err != nil || !bytes.HasPrefix(src, []byte(lfsPointerPrefix)) {
+		return nil, false
+	}
+
+	var oid string
+	size := int64(-1)
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		k, v, _ := strings.Cut(sc.Text(), " ")
+		switch k {
+		case "oid":
+			oid = v
+		case "size":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				size = n
+			}
+		}
+	}
+	if oid == "" || size < 0 {
+		return nil, false
+	}
+	return fmt.Appendf(nil, "git-lfs object %s, %d bytes (pointer file, content not embedded)\n", oid, size), true
+}
@@ -3,13 +3,22 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"io/fs"
+	"maps"
+	"math"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -18,6 +27,8 @@ import (
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pkoukk/tiktoken-go"
 )
 
 type node struct {
@@ -32,6 +43,22 @@ type node struct {
 	expanded bool
 
 	selected bool // only meaningful for files
+	empty    bool // directory without listed files, see -show-empty-dirs
+	hidden   bool // file taken out of view with "h", see hide.go
+
+	// err is why a file can't be read (e.g. removed or no permission since
+	// it was listed); such files are shown with the error and can't be
+	// selected.
+	err error
+
+	modTime time.Time // files only, see statTree
+	size    int64     // files only, see statTree
+	lines   int64     // with -lines; directories hold their subtree total
+
+	last bool // last child of its parent, for tree guides
+
+	diff *diffStat // -diff/-last-commits line counts, nil otherwise
+	git  gitState  // in a repository, what git status says about the file
 }
 
 func newDir(parent *node, name, relBase string) *node {
@@ -89,17 +116,213 @@ func finalizeTree(n *node) {
 		}
 		return a.name < b.name
 	})
-	for _, c := range n.children {
+	for i, c := range n.children {
+		c.last = i == len(n.children)-1
 		finalizeTree(c)
 	}
 	// Collapse by default if more than 32 immediate elements.
 	n.expanded = len(n.children) <= 32
 }
 
+// countTreeLines fills in line counts, summing them up into directories.
+// Files that can't be read keep the error on the node and count as zero.
+func countTreeLines(base string, n *node) int64 {
+	if !n.isDir {
+		lines, err := countLines(filepath.Join(base, n.relBase))
+		if err != nil {
+			n.err = err
+		}
+		n.lines = lines
+		return n.lines
+	}
+	n.lines = 0
+	for _, c := range n.children {
+		n.lines += countTreeLines(base, c)
+	}
+	return n.lines
+}
+
+// countLines counts lines the way editors do: a final line without a
+// trailing newline still counts.
+func countLines(abs string) (int64, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	var lines int64
+	var last byte
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != 0 && last != '\n' {
+		lines++
+	}
+	return lines, nil
+}
+
+func countSelected(n *node) int {
+	c := 0
+	if !n.isDir && n.selected {
+		c++
+	}
+	for _, ch := range n.children {
+		c += countSelected(ch)
+	}
+	return c
+}
+
+func clearSelection(n *node) {
+	n.selected = false
+	for _, c := range n.children {
+		clearSelection(c)
+	}
+}
+
+// selectable reports whether n is a file that can go into the output.
+func (n *node) selectable() bool {
+	return !n.isDir && n.err == nil && !n.hidden
+}
+
+// setSubtree selects or deselects every selectable file under n.
+func (n *node) setSubtree(selected bool) {
+	if !n.isDir {
+		if n.selectable() {
+			n.selected = selected
+		}
+		return
+	}
+	for _, c := range n.children {
+		c.setSubtree(selected)
+	}
+}
+
+// dirSelection counts, in one pass, the files under every directory and how
+// many of them are selected: [files, selected].
+func dirSelection(root *node) map[*node][2]int {
+	out := make(map[*node][2]int)
+	var walk func(*node) [2]int
+	walk = func(n *node) [2]int {
+		if !n.isDir {
+			if !n.selectable() {
+				return [2]int{}
+			}
+			if n.selected {
+				return [2]int{1, 1}
+			}
+			return [2]int{1, 0}
+		}
+		var c [2]int
+		for _, ch := range n.children {
+			cc := walk(ch)
+			c[0] += cc[0]
+			c[1] += cc[1]
+		}
+		out[n] = c
+		return c
+	}
+	walk(root)
+	return out
+}
+
+// selectedSize sums the sizes of the selected files under n.
+func selectedSize(n *node) int64 {
+	if !n.isDir {
+		if n.selected {
+			return n.size
+		}
+		return 0
+	}
+	var total int64
+	for _, c := range n.children {
+		total += selectedSize(c)
+	}
+	return total
+}
+
+// humanBytes formats a size for the status line: 512B, 48KB, 1.2MB.
+func humanBytes(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%dB", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%dKB", (n+1<<9)>>10)
+	default:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	}
+}
+
+// humanCount formats a count for the status line: 950, 12k, 1.2M.
+func humanCount(n int64) string {
+	switch {
+	case n < 1000:
+		return fmt.Sprint(n)
+	case n < 1_000_000:
+		return fmt.Sprintf("%dk", (n+500)/1000)
+	default:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	}
+}
+
+// statTree caches modification times and sizes on file nodes. Files that
+// can't be stat'ed keep the error on the node, see node.err.
+func statTree(base string, n *node) {
+	if !n.isDir {
+		st, err := os.Stat(filepath.Join(base, n.relBase))
+		if err != nil {
+			n.err = err
+			return
+		}
+		n.modTime = st.ModTime()
+		n.size = st.Size()
+		return
+	}
+	for _, c := range n.children {
+		statTree(base, c)
+	}
+}
+
+// guidePrefix renders `tree`-style guides for n: a "│  " column for every
+// ancestor that has siblings below it, then "├─ " or "└─ " for n itself.
+func guidePrefix(n *node) string {
+	if n.parent == nil {
+		return ""
+	}
+	cols := make([]string, n.depth)
+	if n.last {
+		cols[n.depth-1] = "└─ "
+	} else {
+		cols[n.depth-1] = "├─ "
+	}
+	for a, i := n.parent, n.depth-2; i >= 0; a, i = a.parent, i-1 {
+		if a.last {
+			cols[i] = "   "
+		} else {
+			cols[i] = "│  "
+		}
+	}
+	return strings.Join(cols, "")
+}
+
 func flattenVisible(root *node) []*node {
 	var out []*node
 	var walk func(*node)
 	walk = func(n *node) {
+		if n.hidden {
+			return
+		}
 		out = append(out, n)
 		if n.isDir && n.expanded {
 			for _, c := range n.children {
@@ -111,6 +334,26 @@ func flattenVisible(root *node) []*node {
 	return out
 }
 
+// sibling returns the node dir positions away from n among its parent's
+// children, or nil at either end (and for the root).
+func sibling(n *node, dir int) *node {
+	if n.parent == nil {
+		return nil
+	}
+	sibs := n.parent.children
+	for i, c := range sibs {
+		if c != n {
+			continue
+		}
+		j := i + dir
+		if j < 0 || j >= len(sibs) {
+			return nil
+		}
+		return sibs[j]
+	}
+	return nil
+}
+
 func indexOf(nodes []*node, target *node) int {
 	for i := range nodes {
 		if nodes[i] == target {
@@ -120,53 +363,223 @@ func indexOf(nodes []*node, target *node) int {
 	return 0
 }
 
+// setExpandedAll expands every directory, or collapses all but the root.
+func (m *model) setExpandedAll(expanded bool) {
+	m.setExpansion(func(*node) bool { return expanded })
+}
+
+// invertExpanded flips every directory but the root between expanded and
+// collapsed.
+func (m *model) invertExpanded() {
+	m.setExpansion(func(n *node) bool { return !n.expanded })
+}
+
+// setExpansion sets every directory's expanded flag to next(dir) (the root
+// stays expanded), keeping the cursor on its node or, if that got folded
+// away, the nearest visible ancestor.
+func (m *model) setExpansion(next func(*node) bool) {
+	var walk func(*node)
+	walk = func(n *node) {
+		if n.isDir {
+			n.expanded = n == m.root || next(n)
+			for _, c := range n.children {
+				walk(c)
+			}
+		}
+	}
+	walk(m.root)
+
+	old := m.vis[m.cursor]
+	m.vis = m.visible()
+	for n := old; n != nil; n = n.parent {
+		if i := slices.Index(m.vis, n); i >= 0 {
+			m.cursor = i
+			break
+		}
+	}
+	m.ensureCursorVisible()
+}
+
 type keyMap struct {
-	Up      key.Binding
-	Down    key.Binding
-	Right   key.Binding
-	Left    key.Binding
-	Toggle  key.Binding
-	Confirm key.Binding
-	Quit    key.Binding
+	Up        key.Binding
+	Down      key.Binding
+	PageUp    key.Binding
+	PageDown  key.Binding
+	Home      key.Binding
+	End       key.Binding
+	Right     key.Binding
+	Left      key.Binding
+	Parent    key.Binding
+	Expand    key.Binding
+	Collapse  key.Binding
+	Invert    key.Binding
+	NextSib   key.Binding
+	PrevSib   key.Binding
+	Toggle    key.Binding
+	AllVis    key.Binding
+	ClearAll  key.Binding
+	Confirm   key.Binding
+	BuildOne  key.Binding
+	Copy      key.Binding
+	Review    key.Binding
+	Set       key.Binding
+	MoveUp    key.Binding
+	MoveDown  key.Binding
+	Filter    key.Binding
+	ExtFilter key.Binding
+	GitFilter key.Binding
+	Search    key.Binding
+	Preview   key.Binding
+	Hide      key.Binding
+	Unhide    key.Binding
+	Help      key.Binding
+	Quit      key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Up, k.Down, k.Left, k.Right, k.Toggle, k.Confirm, k.Quit}
+	return []key.Binding{k.Up, k.Down, k.PageUp, k.PageDown, k.Left, k.Right, k.Expand, k.Collapse, k.Toggle, k.AllVis, k.ClearAll, k.Confirm, k.BuildOne, k.Review, k.Help, k.Quit}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.Up, k.Down, k.Left, k.Right},
-		{k.Toggle, k.Confirm, k.Quit},
+		{k.Up, k.Down, k.PageUp, k.PageDown, k.Home, k.End, k.Left, k.Right},
+		{k.Parent, k.PrevSib, k.NextSib, k.Expand, k.Collapse, k.Invert},
+		{k.Toggle, k.AllVis, k.ClearAll, k.Confirm, k.BuildOne, k.Copy},
+		{k.Search, k.Filter, k.ExtFilter, k.GitFilter, k.Preview, k.Hide, k.Unhide, k.Help, k.Quit},
+		{k.Review, k.MoveUp, k.MoveDown, k.Set},
 	}
 }
 
 func defaultKeyMap() keyMap {
 	return keyMap{
 		Up: key.NewBinding(
-			key.WithKeys("up"),
-			key.WithHelp("↑", "up"),
+			key.WithKeys("up", "k"),
+			key.WithHelp("↑/k", "up"),
 		),
 		Down: key.NewBinding(
-			key.WithKeys("down"),
-			key.WithHelp("↓", "down"),
+			key.WithKeys("down", "j"),
+			key.WithHelp("↓/j", "down"),
+		),
+		PageUp: key.NewBinding(
+			key.WithKeys("pgup"),
+			key.WithHelp("pgup", "page up"),
+		),
+		PageDown: key.NewBinding(
+			key.WithKeys("pgdown"),
+			key.WithHelp("pgdn", "page down"),
+		),
+		Home: key.NewBinding(
+			key.WithKeys("home"),
+			key.WithHelp("home", "first"),
+		),
+		End: key.NewBinding(
+			key.WithKeys("end"),
+			key.WithHelp("end", "last"),
 		),
 		Right: key.NewBinding(
-			key.WithKeys("right"),
-			key.WithHelp("→", "expand"),
+			key.WithKeys("right", "l"),
+			key.WithHelp("→/l", "expand"),
 		),
 		Left: key.NewBinding(
-			key.WithKeys("left"),
-			key.WithHelp("←", "collapse"),
+			key.WithKeys("left", "h"),
+			key.WithHelp("←/h", "collapse"),
+		),
+		Parent: key.NewBinding(
+			key.WithKeys("backspace"),
+			key.WithHelp("bksp", "parent"),
+		),
+		Expand: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "expand all"),
+		),
+		Collapse: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "collapse all"),
+		),
+		Invert: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "invert expansion"),
+		),
+		NextSib: key.NewBinding(
+			key.WithKeys("]"),
+			key.WithHelp("]", "next sibling"),
+		),
+		PrevSib: key.NewBinding(
+			key.WithKeys("["),
+			key.WithHelp("[", "prev sibling"),
 		),
 		Toggle: key.NewBinding(
 			key.WithKeys(" "),
 			key.WithHelp("space", "toggle"),
 		),
+		AllVis: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "select visible"),
+		),
+		ClearAll: key.NewBinding(
+			key.WithKeys("A"),
+			key.WithHelp("A", "deselect all"),
+		),
 		Confirm: key.NewBinding(
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "build"),
 		),
+		BuildOne: key.NewBinding(
+			key.WithKeys("B"),
+			key.WithHelp("B", "build this file"),
+		),
+		Copy: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "copy to clipboard"),
+		),
+		Review: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "order"),
+		),
+		Set: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s 1-9", "selection set"),
+		),
+		MoveUp: key.NewBinding(
+			key.WithKeys("<"),
+			key.WithHelp("<", "move earlier"),
+		),
+		MoveDown: key.NewBinding(
+			key.WithKeys(">"),
+			key.WithHelp(">", "move later"),
+		),
+		Filter: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "filter"),
+		),
+		ExtFilter: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "cycle ext filter"),
+		),
+		GitFilter: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "cycle git status filter"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys("/"),
+			key.WithHelp("/", "search"),
+		),
+		Preview: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "preview"),
+		),
+		Hide: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "hide file"),
+		),
+		Unhide: key.NewBinding(
+			key.WithKeys("X"),
+			key.WithHelp("X", "show hidden"),
+		),
+		Help: key.NewBinding(
+			key.WithKeys("?"),
+			key.WithHelp("?", "all keys"),
+		),
 		Quit: key.NewBinding(
 			key.WithKeys("q", "esc"),
 			key.WithHelp("q/esc", "quit"),
@@ -186,8 +599,69 @@ type model struct {
 	base        string
 	inRepo      bool
 	allowBinary bool
+	ageColors   bool   // tint recently modified files
+	guides      bool   // draw tree guides instead of plain indentation
+	indent      int    // spaces per depth level without guides
+	orderBy     string // default output order, see sortNodes
+	showLines   bool   // line counts next to names
+	now         time.Time
 
 	selectedCount int
+	hiddenCount   int   // files hidden with "x"
+	maxTokens     int64 // token budget shown in the status line, 0 = none
+
+	// Filter: only files whose path contains filter are listed; while
+	// filtering, keystrokes edit it.
+	filter    string
+	filtering bool
+	filterExt string // extension filter cycled with "t", e.g. ".go"; "" = off
+
+	filterStatus statusFilter // git status filter cycled with "g"
+	gitStatusSet bool         // git status is on the nodes, see cycleStatusFilter
+
+	// Search: "/" prompt that jumps to the first name containing
+	// searchQuery, starting from searchFrom (the cursor when it opened).
+	searching   bool
+	searchQuery string
+	searchFrom  int
+
+	showHelp bool // full-screen key list, see viewHelpOverlay
+
+	// Preview pane, see preview.go; previewLines belong to previewNode.
+	previewing   bool
+	previewNode  *node
+	previewLines []string
+
+	opts  buildOptions // for building to the clipboard ("y")
+	flash string       // one-off status note, cleared by the next key
+
+	// Confirmation screen after enter, see confirm.go.
+	confirming bool
+
+	// Review pane: explicit output order, nil until first opened.
+	reviewing    bool
+	order        []*node
+	reviewCursor int
+
+	// Anchor for right-click range selection, see mouse.go.
+	lastClicked *node
+
+	// Pending count prefix for movement keys (e.g. 5↓), 0 if none.
+	count int
+
+	// Held Up/Down keys are folded into one cursor move per frame, see
+	// queueMove. lastView is the last rendered tree, shown while a move is
+	// pending; it is a pointer so View can update it.
+	moveBy   int
+	moveTick bool
+	frame    time.Duration
+	lastView *string
+
+	// Selection sets, see sets.go. activeSet is 1..9; setPending is set by
+	// s, and the next digit picks the set.
+	activeSet  int
+	sets       map[int]savedSet
+	setPending bool
 
 	keys keyMap
 	help help.Model
@@ -203,13 +677,72 @@ func newModel(root *node, base string, inRepo bool, allowBinary bool) model {
 		base:        base,
 		inRepo:      inRepo,
 		allowBinary: allowBinary,
+		ageColors:   true,
+		indent:      2,
+		now:         time.Now(),
+		activeSet:   1,
 		keys:        defaultKeyMap(),
 		help:        help.New(),
+		frame:       time.Second / 60,
+		lastView:    new(string),
 	}
 	return m
 }
 
-func (m model) Init() tea.Cmd { return nil }
+// moveFlushMsg applies the cursor moves queued since the last frame.
+type moveFlushMsg struct{}
+
+// queueMove adds delta to the pending cursor move. Key repeat is faster than
+// a frame on big trees, so the moves of one frame are applied, and the tree
+// redrawn, once.
+func (m model) queueMove(delta int) (tea.Model, tea.Cmd) {
+	m.moveBy += delta
+	if m.moveTick {
+		return m, nil
+	}
+	m.moveTick = true
+	return m, tea.Tick(m.frame, func(time.Time) tea.Msg { return moveFlushMsg{} })
+}
+
+// flushMove applies the pending cursor move, if any.
+func (m *model) flushMove() {
+	if m.moveBy == 0 {
+		return
+	}
+	m.cursor = min(max(m.cursor+m.moveBy, 0), len(m.vis)-1)
+	m.moveBy = 0
+	m.ensureCursorVisible()
+}
+
+// isMove reports whether msg is an Up/Down key in the tree itself, one
+// queueMove can fold.
+func (m model) isMove(msg tea.Msg) bool {
+	k, ok := msg.(tea.KeyMsg)
+	if !ok || m.confirming || m.reviewing || m.showHelp || m.filtering || m.searching || m.setPending {
+		return false
+	}
+	return key.Matches(k, m.keys.Up, m.keys.Down)
+}
+
+func (m model) Init() tea.Cmd { return windowTitle(m.selectedCount) }
+
+// windowTitle keeps the terminal title in sync with the selection so the
+// tab says what it is doing.
+func windowTitle(selected int) tea.Cmd {
+	return tea.SetWindowTitle(fmt.Sprintf("mkctx — %d selected", selected))
+}
+
+// countDigit reports whether msg is a plain digit key and its value.
+func countDigit(msg tea.KeyMsg) (int, bool) {
+	if msg.Type != tea.KeyRunes || msg.Alt || len(msg.Runes) != 1 {
+		return 0, false
+	}
+	r := msg.Runes[0]
+	if r < '0' || r > '9' {
+		return 0, false
+	}
+	return int(r - '0'), true
+}
 
 func (m *model) viewportHeight() int {
 	h := m.height - 2 // 1 status line + 1 help line
@@ -251,6 +784,26 @@ func (m *model) ensureCursorVisible() {
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	n := next.(model)
+	if n.selectedCount != m.selectedCount {
+		cmd = tea.Batch(cmd, windowTitle(n.selectedCount))
+	}
+	n.refreshPreview()
+	return n, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Anything but another Up/Down sees the cursor where the keys left it.
+	if _, ok := msg.(moveFlushMsg); ok {
+		m.moveTick = false
+		m.flushMove()
+		return m, nil
+	}
+	if !m.isMove(msg) {
+		m.flushMove()
+	}
+
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -259,23 +812,127 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.ensureCursorVisible()
 		return m, nil
 
+	case tea.MouseMsg:
+		if m.confirming {
+			return m, nil // the selection being confirmed stays as shown
+		}
+		return m.updateMouse(msg)
+
+	case clipCopiedMsg:
+		m.flash = clipFlash(msg)
+		return m, nil
+
 	case tea.KeyMsg:
+		m.flash = ""
+		if m.confirming {
+			return m.updateConfirm(msg)
+		}
+		if m.reviewing {
+			return m.updateReview(msg)
+		}
+		if m.showHelp {
+			return m.updateHelpOverlay(msg)
+		}
+		if m.filtering {
+			if fm, handled := m.updateFilter(msg); handled {
+				return fm, nil
+			}
+		}
+		if m.searching {
+			if sm, handled := m.updateSearch(msg); handled {
+				return sm, nil
+			}
+		}
+
+		// s then 1-9 switches sets; any other key cancels and goes on as
+		// usual.
+		if m.setPending {
+			m.setPending = false
+			if d, ok := countDigit(msg); ok {
+				if d > 0 {
+					m.switchSet(d)
+				}
+				return m, nil
+			}
+		}
+
+		// Vim-style count prefix: digits accumulate, the next key uses and
+		// resets it.
+		if d, ok := countDigit(msg); ok && (m.count > 0 || d > 0) {
+			m.count = min(m.count*10+d, 1_000_000)
+			return m, nil
+		}
+		count := max(m.count, 1)
+		m.count = 0
+
 		switch {
 		case key.Matches(msg, m.keys.Quit):
+			if msg.Type == tea.KeyEsc && (m.filter != "" || m.filterExt != "" || m.filterStatus != statusAll) {
+				m.filter, m.filterExt, m.filterStatus = "", "", statusAll
+				m.refilter()
+				return m, nil
+			}
 			m.aborted = true
 			return m, tea.Quit
 
+		case key.Matches(msg, m.keys.Filter):
+			m.filtering = true
+			return m, nil
+
+		case key.Matches(msg, m.keys.ExtFilter):
+			m.cycleExtFilter()
+			return m, nil
+
+		case key.Matches(msg, m.keys.GitFilter):
+			m.cycleStatusFilter()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Help):
+			m.showHelp = true
+			return m, nil
+
+		case key.Matches(msg, m.keys.Hide):
+			m.hideCursor()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Unhide):
+			m.unhideAll()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Preview):
+			m.previewing = !m.previewing
+			m.previewNode = nil
+			return m, nil
+
+		case key.Matches(msg, m.keys.Search):
+			m.searching = true
+			m.searchQuery = ""
+			m.searchFrom = m.cursor
+			return m, nil
+
 		case key.Matches(msg, m.keys.Up):
-			if m.cursor > 0 {
-				m.cursor--
-			}
+			return m.queueMove(-count)
+
+		case key.Matches(msg, m.keys.Down):
+			return m.queueMove(count)
+
+		case key.Matches(msg, m.keys.PageUp):
+			m.cursor = max(m.cursor-count*m.viewportHeight(), 0)
 			m.ensureCursorVisible()
 			return m, nil
 
-		case key.Matches(msg, m.keys.Down):
-			if m.cursor < len(m.vis)-1 {
-				m.cursor++
-			}
+		case key.Matches(msg, m.keys.PageDown):
+			m.cursor = min(m.cursor+count*m.viewportHeight(), len(m.vis)-1)
+			m.ensureCursorVisible()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Home):
+			m.cursor = 0
+			m.ensureCursorVisible()
+			return m, nil
+
+		case key.Matches(msg, m.keys.End):
+			m.cursor = len(m.vis) - 1
 			m.ensureCursorVisible()
 			return m, nil
 
@@ -284,7 +941,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if n.isDir && !n.expanded && len(n.children) > 0 {
 				n.expanded = true
 				old := n
-				m.vis = flattenVisible(m.root)
+				m.vis = m.visible()
 				m.cursor = indexOf(m.vis, old)
 				m.ensureCursorVisible()
 			}
@@ -295,36 +952,147 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if n.isDir && n.expanded && len(n.children) > 0 {
 				n.expanded = false
 				old := n
-				m.vis = flattenVisible(m.root)
+				m.vis = m.visible()
 				m.cursor = indexOf(m.vis, old)
 				m.ensureCursorVisible()
 			}
 			return m, nil
 
+		case key.Matches(msg, m.keys.Expand):
+			m.setExpandedAll(true)
+			return m, nil
+
+		case key.Matches(msg, m.keys.Collapse):
+			m.setExpandedAll(false)
+			return m, nil
+
+		case key.Matches(msg, m.keys.Invert):
+			m.invertExpanded()
+			return m, nil
+
+		case key.Matches(msg, m.keys.Parent):
+			if p := m.vis[m.cursor].parent; p != nil {
+				m.cursor = indexOf(m.vis, p)
+				m.ensureCursorVisible()
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.NextSib):
+			if s := sibling(m.vis[m.cursor], +1); s != nil {
+				m.cursor = indexOf(m.vis, s)
+				m.ensureCursorVisible()
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.PrevSib):
+			if s := sibling(m.vis[m.cursor], -1); s != nil {
+				m.cursor = indexOf(m.vis, s)
+				m.ensureCursorVisible()
+			}
+			return m, nil
+
 		case key.Matches(msg, m.keys.Toggle):
 			n := m.vis[m.cursor]
-			if !n.isDir {
-				n.selected = !n.selected
-				if n.selected {
-					m.selectedCount++
-				} else {
-					m.selectedCount--
+			if n.isDir {
+				// Select the whole subtree unless it is already fully selected.
+				c := dirSelection(n)[n]
+				n.setSubtree(c[1] < c[0])
+				m.selectedCount = countSelected(m.root)
+				return m, nil
+			}
+			if !n.selectable() {
+				return m, nil
+			}
+			n.selected = !n.selected
+			if n.selected {
+				m.selectedCount++
+			} else {
+				m.selectedCount--
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.AllVis):
+			for _, n := range m.vis {
+				if n.selectable() {
+					n.selected = true
 				}
 			}
+			m.selectedCount = countSelected(m.root)
+			return m, nil
+
+		case key.Matches(msg, m.keys.ClearAll):
+			clearSelection(m.root)
+			m.selectedCount = countSelected(m.root)
 			return m, nil
 
 		case key.Matches(msg, m.keys.Confirm):
-			m.confirmed = true
-			return m, tea.Quit
-		}
-	}
-	return m, nil
-}
+			m.confirming = true
+			return m, nil
 
-func (m model) View() string {
-	if len(m.vis) == 0 {
+		case key.Matches(msg, m.keys.Review):
+			m.order = m.orderedSelection()
+			m.reviewCursor = 0
+			m.reviewing = true
+			return m, nil
+
+		case key.Matches(msg, m.keys.Set):
+			m.setPending = true
+			return m, nil
+
+		case key.Matches(msg, m.keys.Copy):
+			if m.selectedCount == 0 {
+				m.flash = "nothing selected"
+				return m, nil
+			}
+			m.flash = "copying..."
+			return m, m.copySelection()
+
+		case key.Matches(msg, m.keys.BuildOne):
+			n := m.vis[m.cursor]
+			if !n.selectable() {
+				return m, nil
+			}
+			clearSelection(m.root)
+			n.selected = true
+			m.selectedCount = 1
+			m.confirmed = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m model) linesSuffix(n *node) string {
+	if !m.showLines {
+		return ""
+	}
+	return fmt.Sprintf("  %d lines", n.lines)
+}
+
+func (m model) View() string {
+	if m.moveBy != 0 && m.lastView != nil && *m.lastView != "" {
+		return *m.lastView // redrawn when the move is applied
+	}
+	v := m.view()
+	if m.lastView != nil {
+		*m.lastView = v
+	}
+	return v
+}
+
+func (m model) view() string {
+	if len(m.vis) == 0 {
 		return ""
 	}
+	if m.confirming {
+		return m.viewConfirm()
+	}
+	if m.reviewing {
+		return m.viewReview()
+	}
+	if m.showHelp {
+		return m.viewHelpOverlay()
+	}
 
 	mode := "fs"
 	if m.inRepo {
@@ -334,7 +1102,38 @@ func (m model) View() string {
 	if m.allowBinary {
 		bin = "text+bin"
 	}
-	status := fmt.Sprintf("%s | %s | selected=%d", mode, bin, m.selectedCount)
+	status := fmt.Sprintf("%s | %s | %s | selected=%d", mode, bin, m.setsStatus(), m.selectedCount)
+	if m.selectedCount > 0 {
+		size := selectedSize(m.root)
+		tokens := humanCount(selectedTokens(m.root, m.opts.ratios))
+		if m.maxTokens > 0 {
+			tokens += "/" + humanCount(m.maxTokens)
+		}
+		status += fmt.Sprintf(" | ~%s | ~%s tok", humanBytes(size), tokens)
+	}
+	if m.hiddenCount > 0 {
+		status += fmt.Sprintf(" | hidden=%d", m.hiddenCount)
+	}
+	if m.flash != "" {
+		status += " | " + m.flash
+	}
+	if m.count > 0 {
+		status += fmt.Sprintf(" | %d", m.count)
+	}
+	if m.setPending {
+		status += " | set _"
+	}
+	if m.filtering {
+		status += " | filter: " + m.filter + "_"
+	} else if m.filter != "" {
+		status += " | filter: " + m.filter
+	}
+	if m.filterExt != "" {
+		status += " | ext: " + m.filterExt
+	}
+	if m.filterStatus != statusAll {
+		status += " | git: " + m.filterStatus.String()
+	}
 
 	vh := m.viewportHeight()
 	start := m.offset
@@ -347,20 +1146,41 @@ func (m model) View() string {
 	b.WriteString(status)
 	b.WriteByte('\n')
 
+	// Directory boxes need subtree counts; one walk per frame covers all rows.
+	dirCounts := dirSelection(m.root)
+
+	tree := &b
+	if m.previewing && m.width > 0 {
+		tree = &strings.Builder{}
+	}
+
 	for i := start; i < end; i++ {
 		n := m.vis[i]
 		cur := " "
 		if i == m.cursor {
 			cur = ">"
 		}
-		indent := strings.Repeat("  ", n.depth)
+		indent := strings.Repeat(" ", m.indent*n.depth)
+		if m.guides {
+			indent = guidePrefix(n)
+		}
 
 		if n.isDir {
 			icon := "▸"
 			if n.expanded {
 				icon = "▾"
 			}
-			fmt.Fprintf(&b, "%s%s%s %s/\n", cur, indent, icon, n.name)
+			note := m.linesSuffix(n)
+			box := "[ ] "
+			if n.empty {
+				note = "  (empty)"
+				box = ""
+			} else if c := dirCounts[n]; c[0] > 0 && c[1] == c[0] {
+				box = "[x] "
+			} else if c[1] > 0 {
+				box = "[~] "
+			}
+			fmt.Fprintf(tree, "%s%s%s %s%s/%s\n", cur, indent, icon, box, highlightMatch(n.name, m.searchQuery, agePlain), note)
 			continue
 		}
 
@@ -368,13 +1188,49 @@ func (m model) View() string {
 		if n.selected {
 			box = "[x]"
 		}
-		fmt.Fprintf(&b, "%s%s%s %s\n", cur, indent, box, n.name)
+		style := agePlain
+		if m.ageColors {
+			style = ageStyle(m.now.Sub(n.modTime))
+		}
+		note := m.linesSuffix(n) + diffSuffix(n)
+		if n.err != nil {
+			box = "[!]"
+			note = "  (" + unwrapPathError(n.err).Error() + ")"
+		}
+		name := highlightMatch(n.name, m.searchQuery, style)
+		fmt.Fprintf(tree, "%s%s%s %s%s\n", cur, indent, box, name, note)
+	}
+	if tree != &b {
+		b.WriteString(m.withPreview(tree.String(), vh))
 	}
 
-	b.WriteString(m.help.View(m.keys))
+	if m.searching {
+		b.WriteString("/" + m.searchQuery + "_")
+	} else {
+		b.WriteString(m.help.View(m.keys))
+	}
 	return b.String()
 }
 
+var (
+	ageDayStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("208"))
+	ageWeekStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("179"))
+	agePlain     = lipgloss.NewStyle()
+)
+
+// ageStyle picks the tint for a file last modified age ago: "hot" files
+// (touched today) stand out, this week's are subtler, older ones are plain.
+func ageStyle(age time.Duration) lipgloss.Style {
+	switch {
+	case age < 24*time.Hour:
+		return ageDayStyle
+	case age < 7*24*time.Hour:
+		return ageWeekStyle
+	default:
+		return agePlain
+	}
+}
+
 func findGitRoot(start string) (string, bool) {
 	dir := start
 	for {
@@ -390,9 +1246,14 @@ func findGitRoot(start string) (string, bool) {
 	}
 }
 
-func gitListFiles(base string, startRelSlash string) []string {
+func gitListFiles(base string, startRelSlash string) ([]string, error) {
+	// -z already disables quoting, core.quotePath=false just makes sure no
+	// config can turn non-ASCII names into octal escapes. Literal pathspecs
+	// keep directory names containing '*', '?' or '[' from acting as globs.
 	args := []string{
 		"-C", base,
+		"-c", "core.quotePath=false",
+		"--literal-pathspecs",
 		"ls-files",
 		"-z",
 		"--cached",
@@ -404,9 +1265,12 @@ func gitListFiles(base string, startRelSlash string) []string {
 		args = append(args, "--", startRelSlash)
 	}
 
-	out, err := exec.Command("git", args...).Output()
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("git ls-files: %v: %s", err, strings.TrimSpace(stderr.String()))
 	}
 
 	parts := bytes.Split(out, []byte{0})
@@ -417,80 +1281,203 @@ func gitListFiles(base string, startRelSlash string) []string {
 		}
 		files = append(files, string(p)) // already slash-separated
 	}
-	return files
+	return files, nil
 }
 
-func walkFiles(base string) []string {
+// walkFiles lists files under base (fs mode), skipping what .gitignore
+// files along the way ignore. Subdirectories that can't be read are
+// reported on stderr and skipped.
+func walkFiles(base string) ([]string, error) {
 	var files []string
+	ign := newGitignore(base)
 	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			if d.Name() == ".git" {
+			if d != nil && d.IsDir() && path != base {
+				fmt.Fprintf(os.Stderr, "mkctx: skipping %s: %v\n", path, unwrapPathError(err))
 				return fs.SkipDir
 			}
-			return nil
+			return err
 		}
 		rel, err := filepath.Rel(base, path)
 		if err != nil {
 			return err
 		}
-		files = append(files, filepath.ToSlash(rel))
+		relSlash := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" || (relSlash != "." && ign.ignored(relSlash, true)) {
+				return fs.SkipDir
+			}
+			return ign.load(relSlash)
+		}
+		if !ign.ignored(relSlash, false) {
+			files = append(files, relSlash)
+		}
 		return nil
 	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return files
+	return files, nil
 }
 
+// defaultBinarySample is how many leading bytes isBinary looks at unless
+// -binary-sample or -binary-full say otherwise.
+const defaultBinarySample = 8192
+
 // Heuristic binary detection (cheap). Good enough for gating selection.
-// Panic on unexpected errors per requirements.
-func isBinary(path string) bool {
+// Only the first sample bytes are inspected; sample <= 0 scans the whole
+// file. Read errors are returned so callers can decide whether to skip or
+// fail.
+func isBinary(path string, sample int64) (bool, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return false, err
 	}
 	defer f.Close()
 
-	buf := make([]byte, 8192)
-	n, err := f.Read(buf)
-	if err != nil && err != io.EOF {
-		panic(err)
+	var r io.Reader = f
+	if sample > 0 {
+		r = io.LimitReader(f, sample)
 	}
-	b := buf[:n]
-	if len(b) == 0 {
-		return false
+
+	// Read in chunks so -binary-full doesn't hold whole files in memory. A
+	// rune split between two chunks is carried over to the next one.
+	buf := make([]byte, 64<<10)
+	var carry []byte
+	var total, ctrl int64
+	valid := true
+	for {
+		n, err := r.Read(buf[len(carry):])
+		if n > 0 {
+			chunk := buf[len(carry) : len(carry)+n]
+			if bytes.IndexByte(chunk, 0) != -1 {
+				return true, nil
+			}
+			total += int64(n)
+			// Fallback for non-UTF-8 text: control-character ratio.
+			for _, c := range chunk {
+				switch c {
+				case '\n', '\r', '\t', '\f':
+				default:
+					if c < 0x20 || c == 0x7f {
+						ctrl++
+					}
+				}
+			}
+			data := buf[:len(carry)+n]
+			end := len(data)
+			for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+				if utf8.RuneStart(data[i]) {
+					if !utf8.FullRune(data[i:]) {
+						end = i
+					}
+					break
+				}
+			}
+			if valid && !utf8.Valid(data[:end]) {
+				valid = false
+			}
+			carry = append(carry[:0:0], data[end:]...)
+			copy(buf, carry)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, err
+		}
 	}
-	if bytes.IndexByte(b, 0) != -1 {
-		return true
+	if total == 0 {
+		return false, nil
 	}
 	// If it looks like UTF-8, treat as text.
-	if utf8.Valid(b) {
-		return false
+	if valid && len(carry) == 0 {
+		return false, nil
 	}
-	// Otherwise, fall back to control-character ratio.
-	ctrl := 0
-	for _, c := range b {
-		switch c {
-		case '\n', '\r', '\t', '\f':
+	return float64(ctrl)/float64(total) > 0.10, nil
+}
+
+// filterBinaries splits files into text files and binaries. Unreadable files
+// are reported on stderr and dropped from both.
+func filterBinaries(base string, files []string, sample int64) (text, binaries []string) {
+	text = files[:0]
+	for _, relSlash := range files {
+		abs := filepath.Join(base, filepath.FromSlash(relSlash))
+		bin, err := isBinary(abs, sample)
+		if err != nil {
+			// Unreadable files (permissions etc.) are excluded, not fatal.
+			fmt.Fprintf(os.Stderr, "mkctx: skipping %s: %v\n", relSlash, err)
 			continue
-		default:
-			if c < 0x20 || c == 0x7f {
-				ctrl++
-			}
 		}
+		if bin {
+			binaries = append(binaries, relSlash)
+			continue
+		}
+		text = append(text, relSlash)
+	}
+	return text, binaries
+}
+
+// listedBinaries returns the binaries among paths (-stdin), for -strict.
+func listedBinaries(binaries, paths []string) []string {
+	var out []string
+	for _, relSlash := range binaries {
+		if slices.Contains(paths, relSlash) {
+			out = append(out, relSlash)
+		}
+	}
+	return out
+}
+
+// failStrict lists the binaries and exits if there are any (-strict).
+func failStrict(binaries []string) {
+	if len(binaries) == 0 {
+		return
+	}
+	for _, relSlash := range binaries {
+		fmt.Fprintf(os.Stderr, "binary: %s\n", relSlash)
+	}
+	fatalf("-strict: %d binary files matched (use -b to include them)", len(binaries))
+}
+
+// fileDescription returns `file <relSlash>` output for a binary file,
+// trailing newlines trimmed.
+func fileDescription(base, relSlash string) ([]byte, error) {
+	cmd := exec.Command("file", relSlash)
+	cmd.Dir = base
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("file %s: %v", relSlash, err)
 	}
-	return float64(ctrl)/float64(len(b)) > 0.10
+	// Preserve stdout exactly (minus trailing newlines to avoid extra empty lines).
+	return bytes.TrimRight(out, "\n"), nil
+}
+
+// isBinaryIn is isBinary for a file under base, for output code that only
+// asks when binaries are allowed at all.
+func isBinaryIn(base, relSlash string, opts buildOptions) (bool, error) {
+	if !opts.allowBinary {
+		return false, nil
+	}
+	return isBinary(filepath.Join(base, filepath.FromSlash(relSlash)), opts.binarySample)
 }
 
 func buildTree(startRelSlash string, baseRelSlashFiles []string) *node {
 	rootRelOS := filepath.FromSlash(startRelSlash)
 	root := newDir(nil, startRelSlash, rootRelOS)
+	addFiles(root, startRelSlash, baseRelSlashFiles)
+	finalizeTree(root)
+	return root
+}
 
+// addFiles adds the files under startRelSlash to root, the directory node
+// for it.
+func addFiles(root *node, startRelSlash string, baseRelSlashFiles []string) {
 	for _, full := range baseRelSlashFiles {
 		within := full
+		if startRelSlash == "." && isOutside(full) {
+			continue // under another root, outside the repository
+		}
 		if startRelSlash != "." {
 			prefix := startRelSlash + "/"
 			if !strings.HasPrefix(full, prefix) {
@@ -531,29 +1518,41 @@ func buildTree(startRelSlash string, baseRelSlashFiles []string) *node {
 			cur.addChild(f)
 		}
 	}
-
-	finalizeTree(root)
-	return root
 }
 
+// selectedFiles returns base-relative slash paths in output order.
 func (m model) selectedFiles() []string {
-	var out []string
-	var walk func(*node)
-	walk = func(n *node) {
-		if !n.isDir && n.selected {
-			out = append(out, filepath.ToSlash(n.relBase))
-		}
-		if n.isDir {
-			for _, c := range n.children {
-				walk(c)
-			}
-		}
+	nodes := m.orderedSelection()
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		out[i] = filepath.ToSlash(n.relBase)
 	}
-	walk(m.root)
-	sort.Strings(out)
 	return out
 }
 
+func sortNodesByPath(nodes []*node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return filepath.ToSlash(nodes[i].relBase) < filepath.ToSlash(nodes[j].relBase)
+	})
+}
+
+// Output orders for -order.
+const (
+	orderPath     = "path"
+	orderSizeDesc = "size-desc"
+)
+
+// sortNodes puts nodes into the default output order; sizes come from
+// statTree.
+func sortNodes(nodes []*node, order string) {
+	sortNodesByPath(nodes)
+	if order == orderSizeDesc {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].size > nodes[j].size
+		})
+	}
+}
+
 func languageFor(relOS string) string {
 	base := filepath.Base(relOS)
 	switch base {
@@ -624,11 +1623,36 @@ func languageFor(relOS string) string {
 	case "ini", "conf":
 		return "ini"
 	default:
-		return ext
+		// Unknown extensions make invalid info strings for some renderers.
+		return ""
+	}
+}
+
+// languageTally counts selected files per fence language (files without a
+// known language are not counted).
+func languageTally(selectedRelSlash []string) map[string]int {
+	tally := make(map[string]int)
+	for _, relSlash := range selectedRelSlash {
+		if lang := languageFor(filepath.FromSlash(relSlash)); lang != "" {
+			tally[lang]++
+		}
+	}
+	return tally
+}
+
+// primaryLanguage returns the most common language of the selection, ties
+// broken alphabetically; "" if no file has a known language.
+func primaryLanguage(selectedRelSlash []string) string {
+	best, bestN := "", 0
+	for lang, n := range languageTally(selectedRelSlash) {
+		if n > bestN || (n == bestN && lang < best) {
+			best, bestN = lang, n
+		}
 	}
+	return best
 }
 
-func maxRunByteInReader(r io.Reader, b byte) int {
+func maxRunByteInReader(r io.Reader, b byte) (int, error) {
 	buf := make([]byte, 32*1024)
 	maxRun := 0
 	run := 0
@@ -651,19 +1675,38 @@ func maxRunByteInReader(r io.Reader, b byte) int {
 			break
 		}
 		if err != nil {
-			panic(err)
+			return 0, err
 		}
 	}
-	return maxRun
+	return maxRun, nil
 }
 
-func maxRunByteInFile(path string, b byte) int {
-	f, err := os.Open(path)
-	if err != nil {
-		panic(err)
+func maxRunByteInString(s string, b byte) int {
+	n, _ := maxRunByteInReader(strings.NewReader(s), b) // reading a string can't fail
+	return n
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipBOM drops a leading UTF-8 byte order mark (Windows editors like to add
+// one); anything else passes through untouched.
+func skipBOM(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if p, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(p, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
 	}
-	defer f.Close()
-	return maxRunByteInReader(f, b)
+	return br
+}
+
+// fenceInfo renders the info string after an opening fence from the
+// -fence-info template ({lang}, {path}); by default it's just the language.
+func fenceInfo(opts buildOptions, lang, relSlash string) string {
+	if opts.fenceInfo == "" {
+		return lang
+	}
+	info := strings.NewReplacer("{lang}", lang, "{path}", relSlash).Replace(opts.fenceInfo)
+	// Backtick fences can't have backticks in their info string.
+	return strings.TrimSpace(strings.ReplaceAll(info, "`", "'"))
 }
 
 func fenceForContent(maxRun int) string {
@@ -674,182 +1717,1376 @@ func fenceForContent(maxRun int) string {
 	return strings.Repeat("`", n)
 }
 
-func buildMarkdown(base string, selectedRelSlash []string, allowBinary bool) (absOut string, size int64, tokens int64) {
-	outDir := filepath.Join(base, ".mkctx")
-	if err := os.MkdirAll(outDir, 0o755); err != nil {
-		panic(err)
-	}
-
-	name := fmt.Sprintf("source-context-%s.md", time.Now().Format("2006-01-02-15-04-05"))
-	outPath := filepath.Join(outDir, name)
+// buildOptions carries the output switches from the command line into
+// buildMarkdown.
+type buildOptions struct {
+	allowBinary    bool
+	binarySample   int64
+	stripANSI      bool
+	collapseBlanks bool   // squeeze runs of blank lines into one
+	outline        bool   // Go files: signatures only, see goOutline
+	depGraph       bool   // Mermaid graph of imports between selected Go packages
+	importMap      bool   // which imports resolve to selected files (Go, JS/TS)
+	breakdown      bool   // summary splits content and overhead tokens
+	mergeLang      bool   // adjacent same-language files share one fence
+	guessLang      bool   // content heuristic for unknown extensions
+	detectLang     bool   // header comment naming the dominant language
+	treeOverview   bool   // ASCII tree of the selection first, see writeTreeOverview
+	stats          bool   // tables of totals, languages and largest files, see writeStats
+	singleFence    bool   // everything in one outer fence
+	collapsible    bool   // each file in a <details> block, see sectionStart
+	elideLong      int    // Go function bodies longer than this are cut, 0 = off
+	hashes         bool   // sha256 of each file in its header
+	ignoreWS       bool   // with hashes: hash whitespace-collapsed content (sha256-ws:)
+	fenceInfo      string // info string template, see fenceInfo
+	checkEncoding  bool   // warn about files that embed as mojibake
+	perFileTokens  bool   // token estimate in each file's header
+	describe       bool   // one-line summary above each file, see describeFile
+	verbose        bool   // log each file to stderr as it's written
+	markers        bool   // embed only mkctx:start/end regions, see markerFilter
+	numbers        bool   // line numbers in front of embedded lines, see lineNumberer
+	splitAt        int    // max lines per fenced block, see fenceSplitter; 0 = off
+
+	tokenizer *tiktoken.Tiktoken // real token counts instead of estimates, nil = off
+	ratios    *tokenRatios       // bytes per token for estimates
+	maxTokens int64              // budget to warn about, 0 = none
+
+	nameFormat string // see outputName
+	nameSuffix string // appended to the name, e.g. "-set2"
+	zipPath    string // also bundle everything into this archive
+	outDir     string // where the markdown goes, normally <base>/.mkctx
+	output     string // -o: exact markdown path instead, "-" for stdout
+	atRef      string // embed files as of this git ref, see materializeAt
+	branch     string // for {branch} in nameFormat
+
+	redactPaths bool          // replace directory names with placeholders
+	redact      *pathRedactor // set per build when redactPaths
+	headerBase  string        // base-relative slash dir that header paths are shown relative to
+	copyPath    bool          // put the markdown path on the clipboard
+
+	// -resume: progress is set per build by writeMarkdownResumable; the
+	// file sections start at resumeFrom and call checkpoint after each.
+	resume       bool
+	progress     *buildProgress
+	progressPath string
+	resumeFrom   int
+	checkpoint   func(done int) error
+	clip         bool // put the markdown itself on the clipboard
+
+	exclude []string // -exclude patterns, see excludeSelected
+
+	// -dedup: duplicates is set per build by buildMarkdown, see
+	// findDuplicates.
+	dedup      bool
+	duplicates map[string]string
+
+	// dirListings lists, before the file sections, every entry of tree in
+	// the directories of selected files; listExcluded lists, after them,
+	// every file of tree that is not selected.
+	dirListings  bool
+	listExcluded bool
+	tree         *node
+
+	// listings and excluded are what dirListings and listExcluded take from
+	// tree, when tree is nil because the caller took them beforehand (see
+	// copySelection).
+	listings map[string][]string
+	excluded []string
+}
 
-	f, err := os.Create(outPath)
-	if err != nil {
-		panic(err)
-	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			//panic(err)
+// collectDirListings returns, for every directory containing a selected file,
+// the full list of entries the tree knows about in that directory.
+func collectDirListings(root *node, selectedRelSlash []string) map[string][]string {
+	dirs := make(map[string]*node)
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			return
 		}
-	}()
-
-	w := bufio.NewWriter(f)
-	defer func() {
-		if err := w.Flush(); err != nil {
-			panic(err)
+		dirs[filepath.ToSlash(n.relBase)] = n
+		for _, c := range n.children {
+			walk(c)
 		}
-	}()
+	}
+	walk(root)
 
+	out := make(map[string][]string)
 	for _, relSlash := range selectedRelSlash {
-		relOS := filepath.FromSlash(relSlash)
-		abs := filepath.Join(base, relOS)
-
-		fmt.Fprintf(w, "## %s\n\n", relSlash)
-
-		if allowBinary && isBinary(abs) {
-			// Binary file -> `file <relative/path>` output
-			cmd := exec.Command("file", relSlash)
-			cmd.Dir = base
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				panic(err)
-			}
-
-			fmt.Fprintln(w, "```")
-			// Preserve stdout exactly (minus trailing newlines to avoid extra empty lines).
-			out = bytes.TrimRight(out, "\n")
-			maxRun := 0
-			{
-				// ищем максимальную серию '`' в выводе file
-				run := 0
-				for _, c := range out {
-					if c == '`' {
-						run++
-						if run > maxRun {
-							maxRun = run
-						}
-					} else {
-						run = 0
-					}
-				}
+		dir := path.Dir(relSlash)
+		if _, ok := out[dir]; ok {
+			continue
+		}
+		d, ok := dirs[dir]
+		if !ok {
+			continue
+		}
+		entries := make([]string, 0, len(d.children))
+		for _, c := range d.children {
+			if c.isDir {
+				entries = append(entries, c.name+"/")
+			} else {
+				entries = append(entries, c.name)
 			}
-			fence := fenceForContent(maxRun)
+		}
+		out[dir] = entries
+	}
+	return out
+}
 
-			fmt.Fprintln(w, fence)
-			if len(out) > 0 {
-				if _, err := w.Write(out); err != nil {
-					panic(err)
+func writeDirListings(w io.Writer, listings map[string][]string, redact *pathRedactor) {
+	dirs := make([]string, 0, len(listings))
+	for dir := range listings {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		entries := listings[dir]
+		if redact != nil {
+			red := make([]string, len(entries))
+			for i, e := range entries {
+				red[i] = e
+				if sub, ok := strings.CutSuffix(e, "/"); ok {
+					red[i] = path.Base(redact.dir(path.Join(dir, sub))) + "/"
 				}
 			}
-			fmt.Fprintln(w)
-			fmt.Fprintln(w, fence)
-			fmt.Fprintln(w)
-			continue
+			entries = red
 		}
-
-		// Text file -> embed contents
-		maxRun := maxRunByteInFile(abs, '`')
-		fence := fenceForContent(maxRun)
-
-		lang := languageFor(relOS)
-		if lang != "" {
-			fmt.Fprintf(w, "%s%s\n", fence, lang)
-		} else {
-			fmt.Fprintln(w, fence)
+		maxRun := 0
+		for _, e := range entries {
+			maxRun = max(maxRun, maxRunByteInString(e, '`'))
 		}
+		fence := fenceForContent(maxRun)
 
-		in, err := os.Open(abs)
-		if err != nil {
-			panic(err)
-		}
-		_, err = io.Copy(w, in)
-		_ = in.Close()
-		if err != nil {
-			panic(err)
+		fmt.Fprintf(w, "## %s/ (listing)\n\n", redact.dir(dir))
+		fmt.Fprintln(w, fence+"text")
+		for _, e := range entries {
+			fmt.Fprintln(w, e)
 		}
-
-		fmt.Fprintln(w)
 		fmt.Fprintln(w, fence)
 		fmt.Fprintln(w)
 	}
+}
 
-	if err := w.Flush(); err != nil {
-		panic(err)
+// excludedFiles returns base-relative slash paths of the tree's files that
+// are not in selectedRelSlash, in tree order.
+func excludedFiles(root *node, selectedRelSlash []string) []string {
+	selected := make(map[string]bool, len(selectedRelSlash))
+	for _, relSlash := range selectedRelSlash {
+		selected[relSlash] = true
 	}
-	if err := f.Close(); err != nil {
-		panic(err)
+	var out []string
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			if relSlash := filepath.ToSlash(n.relBase); !selected[relSlash] {
+				out = append(out, relSlash)
+			}
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
 	}
+	walk(root)
+	return out
+}
 
-	st, err := os.Stat(outPath)
-	if err != nil {
-		panic(err)
+func writeExcluded(w io.Writer, excluded []string, redact *pathRedactor) {
+	if len(excluded) == 0 {
+		return
+	}
+	lines := make([]string, len(excluded))
+	maxRun := 0
+	for i, relSlash := range excluded {
+		lines[i] = redact.file(relSlash)
+		maxRun = max(maxRun, maxRunByteInString(lines[i], '`'))
 	}
+	fence := fenceForContent(maxRun)
 
-	abs, err := filepath.Abs(outPath)
-	if err != nil {
-		panic(err)
+	fmt.Fprint(w, "## Excluded files\n\n")
+	fmt.Fprint(w, "These files exist in the project but were deliberately left out of this context.\n\n")
+	fmt.Fprintln(w, fence+"text")
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
 	}
+	fmt.Fprintln(w, fence)
+	fmt.Fprintln(w)
+}
+
+// buildStats describes a written context file.
+type buildStats struct {
+	path   string // absolute
+	size   int64
+	tokens int64
 
-	size = st.Size()
-	// Simple estimate: ~4 bytes per token (script-friendly integer).
-	tokens = (size + 3) / 4
-	return abs, size, tokens
+	// contentSize counts embedded file bytes only; the rest of size is
+	// headers, fences and other structure.
+	contentSize   int64
+	contentTokens int64
+
+	deduped int // files left out as duplicates, see findDuplicates
 }
 
-func main() {
-	allowBinary := flag.Bool("b", false, "allow selecting binary files (use `file <relpath>` in output)")
-	flag.Parse()
+func (s buildStats) overheadTokens() int64 { return s.tokens - s.contentTokens }
 
-	cwd, err := os.Getwd()
-	if err != nil {
-		panic(err)
+// countingWriter counts bytes passed through to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// displayPath is how a file's path appears in its header: relative to
+// -header-base if set, then redacted.
+func displayPath(relSlash string, opts buildOptions) string {
+	if opts.headerBase != "" {
+		if rel, err := filepath.Rel(opts.headerBase, relSlash); err == nil {
+			relSlash = filepath.ToSlash(rel)
+		}
 	}
+	return opts.redact.file(relSlash)
+}
 
-	base, inRepo := findGitRoot(cwd)
-	if !inRepo {
-		base = cwd
+// sectionStart opens a file's section: a "## title" heading, or with
+// -collapsible a <details> block whose summary is the title.
+func sectionStart(w io.Writer, title string, opts buildOptions) {
+	if opts.collapsible {
+		fmt.Fprintf(w, "<details>\n<summary>%s</summary>\n\n", html.EscapeString(title))
+		return
 	}
+	fmt.Fprintf(w, "## %s\n\n", title)
+}
 
-	//startRelOS := "." // FIXME
+// sectionEnd closes what sectionStart opened, if anything.
+func sectionEnd(w io.Writer, opts buildOptions) {
+	if opts.collapsible {
+		fmt.Fprint(w, "</details>\n\n")
+	}
+}
+
+// sectionTitle is what follows "## " for a file: its path, plus the content
+// hash with -hashes and the token estimate with -per-file-tokens.
+func sectionTitle(base, relSlash string, opts buildOptions) (string, error) {
+	title := displayPath(relSlash, opts)
+	abs := filepath.Join(base, filepath.FromSlash(relSlash))
+	var notes []string
+	if opts.hashes {
+		note, err := hashNote(abs, opts.ignoreWS)
+		if err != nil {
+			return "", err
+		}
+		notes = append(notes, note)
+	}
+	if opts.perFileTokens {
+		tokens, err := fileTokens(base, relSlash, opts)
+		if err != nil {
+			return "", err
+		}
+		notes = append(notes, fmt.Sprintf("~%d tokens", tokens))
+	}
+	if len(notes) == 0 {
+		return title, nil
+	}
+	return fmt.Sprintf("%s (%s)", title, strings.Join(notes, ", ")), nil
+}
+
+// fileTokens estimates the tokens a file contributes as embedded: its
+// transformed content, or the `file` description for binaries.
+func fileTokens(base, relSlash string, opts buildOptions) (int64, error) {
+	bin, err := isBinaryIn(base, relSlash, opts)
+	if err != nil {
+		return 0, err
+	}
+	if bin {
+		desc, err := fileDescription(base, relSlash)
+		if err != nil {
+			return 0, err
+		}
+		if opts.tokenizer != nil {
+			return int64(len(opts.tokenizer.EncodeOrdinary(string(desc)))), nil
+		}
+		return ratioTokens(int64(len(desc)), opts.ratios.base()), nil
+	}
+	if opts.tokenizer != nil {
+		tc := &tokenCounter{enc: opts.tokenizer}
+		if _, err := copyContent(tc, filepath.Join(base, filepath.FromSlash(relSlash)), opts); err != nil {
+			return 0, err
+		}
+		tc.Flush()
+		return tc.n, nil
+	}
+	cw := &countingWriter{w: io.Discard}
+	if _, err := copyContent(cw, filepath.Join(base, filepath.FromSlash(relSlash)), opts); err != nil {
+		return 0, err
+	}
+	return ratioTokens(cw.n, opts.ratios.forFile(relSlash)), nil
+}
+
+// hashFile returns the hex SHA-256 of the file's raw bytes.
+func hashFile(abs string) (string, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileSections writes the per-file "## path" sections. Structure goes to
+// w, embedded content through content (which counts it).
+func writeFileSections(w io.Writer, content *contentCounter, base string, selectedRelSlash []string, opts buildOptions) error {
+	for i := opts.resumeFrom; i < len(selectedRelSlash); i++ {
+		if opts.checkpoint != nil && i > opts.resumeFrom {
+			if err := opts.checkpoint(i); err != nil {
+				return err
+			}
+		}
+		relSlash := selectedRelSlash[i]
+		relOS := filepath.FromSlash(relSlash)
+		abs := filepath.Join(base, relOS)
+
+		if orig, ok := opts.duplicates[relSlash]; ok {
+			title, err := sectionTitle(base, relSlash, opts)
+			if err != nil {
+				return err
+			}
+			writeDuplicateSection(w, title, orig, opts)
+			logIncluded(base, relSlash, opts)
+			continue
+		}
+
+		bin, err := isBinaryIn(base, relSlash, opts)
+		if err != nil {
+			return err
+		}
+
+		if !bin && opts.mergeLang {
+			group, err := mergeGroup(base, selectedRelSlash[i:], opts)
+			if err != nil {
+				return err
+			}
+			if len(group) > 1 {
+				if err := writeMergedSection(w, content, base, group, opts); err != nil {
+					return err
+				}
+				i += len(group) - 1
+				continue
+			}
+		}
+
+		title, err := sectionTitle(base, relSlash, opts)
+		if err != nil {
+			return err
+		}
+		sectionStart(w, title, opts)
+
+		if bin {
+			// Binary file -> `file <relative/path>` output
+			out, err := fileDescription(base, relSlash)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(w, "```")
+			maxRun := 0
+			{
+				// ищем максимальную серию '`' в выводе file
+				run := 0
+				for _, c := range out {
+					if c == '`' {
+						run++
+						if run > maxRun {
+							maxRun = run
+						}
+					} else {
+						run = 0
+					}
+				}
+			}
+			fence := fenceForContent(maxRun)
+
+			fmt.Fprintln(w, fence)
+			if len(out) > 0 {
+				if _, err := content.Write(out); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, fence)
+			fmt.Fprintln(w)
+			sectionEnd(w, opts)
+			logIncluded(base, relSlash, opts)
+			continue
+		}
+
+		// Text file -> embed contents
+		if opts.describe {
+			if d := describeFile(abs); d != "" {
+				fmt.Fprintf(w, "> %s\n\n", d)
+			}
+		}
+		maxRun, err := maxRunInContent(abs, opts)
+		if err != nil {
+			return err
+		}
+		fence := fenceForContent(maxRun)
+
+		lang := languageFor(relOS)
+		if lang == "" {
+			lang = shebangLanguage(abs)
+		}
+		if lang == "" && opts.guessLang {
+			lang = guessLanguage(abs)
+		}
+		info := fenceInfo(opts, lang, displayPath(relSlash, opts))
+		fmt.Fprintln(w, fence+info)
+
+		var dst io.Writer = content
+		if opts.splitAt > 0 {
+			dst = &fenceSplitter{w: w, content: content, every: opts.splitAt, fence: fence, info: info, label: displayPath(relSlash, opts)}
+		}
+		garbled, err := content.copyFile(dst, abs, opts)
+		if err != nil {
+			return err
+		}
+		if garbled {
+			warnGarbled(relSlash)
+		}
+		logIncluded(base, relSlash, opts)
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, fence)
+		fmt.Fprintln(w)
+		sectionEnd(w, opts)
+	}
+	return nil
+}
+
+// buildMarkdown writes the context document to out. The returned stats
+// have no path; that's up to the caller.
+func buildMarkdown(out io.Writer, base string, selectedRelSlash []string, opts buildOptions) (buildStats, error) {
+	total := &countingWriter{w: out}
+	w := bufio.NewWriter(total)
+	content := &contentCounter{countingWriter: countingWriter{w: w}, ratios: opts.ratios}
+
+	// -tokenizer: count the final output (and the content part of it).
+	var totalTok, contentTok *tokenCounter
+	if opts.tokenizer != nil {
+		totalTok = &tokenCounter{enc: opts.tokenizer}
+		contentTok = &tokenCounter{enc: opts.tokenizer}
+		total.w = io.MultiWriter(out, totalTok)
+		content.w = io.MultiWriter(w, contentTok)
+	}
+
+	if opts.dedup {
+		dups, err := findDuplicates(base, selectedRelSlash)
+		if err != nil {
+			return buildStats{}, err
+		}
+		opts.duplicates = dups
+	}
+
+	// -resume: out already holds the sections of the first p.Done files.
+	if p := opts.progress; p != nil {
+		if p.Done > 0 {
+			opts.resumeFrom = p.Done
+			total.n, content.n, content.tokens = p.Offset, p.Content, p.ContentTokens
+			if totalTok != nil {
+				totalTok.n, contentTok.n = p.TotalTok, p.ContentTok
+			}
+		}
+		opts.checkpoint = func(done int) error {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			p.Done, p.Offset, p.Content, p.ContentTokens = done, total.n, content.n, content.tokens
+			if totalTok != nil {
+				totalTok.Flush()
+				contentTok.Flush()
+				p.TotalTok, p.ContentTok = totalTok.n, contentTok.n
+			}
+			return p.save(opts.progressPath)
+		}
+	}
+	if opts.resumeFrom == 0 { // otherwise they are in the partial file already
+		if err := writeLeadingSections(w, base, selectedRelSlash, opts); err != nil {
+			return buildStats{}, err
+		}
+	}
+
+	var err error
+	if opts.singleFence {
+		err = writeSingleFence(w, content, base, selectedRelSlash, opts)
+	} else {
+		err = writeFileSections(w, content, base, selectedRelSlash, opts)
+	}
+	if err != nil {
+		return buildStats{}, err
+	}
+	if opts.listExcluded {
+		excluded := opts.excluded
+		if opts.tree != nil {
+			excluded = excludedFiles(opts.tree, selectedRelSlash)
+		}
+		writeExcluded(w, excluded, opts.redact)
+	}
+
+	if err := w.Flush(); err != nil {
+		return buildStats{}, err
+	}
+	overhead := float64(total.n-content.n) / opts.ratios.base()
+	st := buildStats{
+		size:          total.n,
+		tokens:        int64(math.Ceil(overhead + content.tokens)),
+		contentSize:   content.n,
+		contentTokens: int64(math.Ceil(content.tokens)),
+		deduped:       len(opts.duplicates),
+	}
+	if totalTok != nil {
+		totalTok.Flush()
+		contentTok.Flush()
+		st.tokens, st.contentTokens = totalTok.n, contentTok.n
+	}
+	return st, nil
+}
+
+// writeLeadingSections writes what comes before the file sections: the
+// primary language comment, stats, tree, import graphs and dir listings.
+func writeLeadingSections(w io.Writer, base string, selectedRelSlash []string, opts buildOptions) error {
+	if opts.detectLang {
+		if lang := primaryLanguage(selectedRelSlash); lang != "" {
+			fmt.Fprintf(w, "<!-- primary: %s -->\n\n", lang)
+		}
+	}
+	if opts.stats {
+		if err := writeStats(w, base, selectedRelSlash, opts); err != nil {
+			return err
+		}
+	}
+	if opts.treeOverview {
+		writeTreeOverview(w, selectedRelSlash, opts)
+	}
+	if opts.depGraph {
+		writeDepGraph(w, base, selectedRelSlash, opts.redact)
+	}
+	if opts.importMap {
+		writeImportMap(w, base, selectedRelSlash, opts.redact)
+	}
+	if opts.dirListings {
+		listings := opts.listings
+		if opts.tree != nil {
+			listings = collectDirListings(opts.tree, selectedRelSlash)
+		}
+		writeDirListings(w, listings, opts.redact)
+	}
+	return nil
+}
+
+// outputPath is where the markdown goes: -o as given, otherwise a name from
+// -name-format in outDir (created if needed).
+func outputPath(opts buildOptions) (string, error) {
+	if opts.output != "" {
+		return withSuffix(opts.output, opts.nameSuffix), nil
+	}
+	if err := os.MkdirAll(opts.outDir, 0o755); err != nil {
+		return "", outDirError(opts.outDir, err)
+	}
+	name, err := outputName(opts.nameFormat, time.Now(), opts.branch)
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSuffix(name, ".md") + opts.nameSuffix + ".md"
+	return filepath.Join(opts.outDir, name), nil
+}
+
+// checkOutDir fails early if dir (or, while it doesn't exist, the directory
+// it would be created in) isn't writable, e.g. in a read-only checkout,
+// rather than after the selection has been made.
+func checkOutDir(dir string) error {
+	probeDir := dir
+	for {
+		if _, err := os.Stat(probeDir); err == nil {
+			break
+		}
+		parent := filepath.Dir(probeDir)
+		if parent == probeDir {
+			break
+		}
+		probeDir = parent
+	}
+	f, err := os.CreateTemp(probeDir, ".mkctx-probe-*")
+	if err != nil {
+		return outDirError(dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func outDirError(dir string, err error) error {
+	return fmt.Errorf("can't write to %s: %v (use -o <path>, or -o - for stdout)", dir, unwrapPathError(err))
+}
+
+// writeMarkdownFile runs buildMarkdown into the file at outPath.
+func writeMarkdownFile(outPath, base string, selectedRelSlash []string, opts buildOptions) (buildStats, error) {
+	if fi, err := os.Stat(outPath); err == nil && !fi.Mode().IsRegular() {
+		// -o /dev/null, a FIFO, ...: a rename would replace it, so write
+		// into it instead.
+		return writeMarkdownInPlace(outPath, base, selectedRelSlash, opts)
+	}
+	if opts.resume {
+		return writeMarkdownResumable(outPath, base, selectedRelSlash, opts)
+	}
+
+	// Write next to the target and rename on success, so readers (e.g. of
+	// -watch output) never see a half-written file.
+	f, err := os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".tmp-*")
+	if err != nil {
+		return buildStats{}, err
+	}
+	done := false
+	defer func() {
+		if !done {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := f.Chmod(0o644); err != nil {
+		return buildStats{}, err
+	}
+
+	st, err := buildMarkdown(f, base, selectedRelSlash, opts)
+	if err != nil {
+		return buildStats{}, err
+	}
+	if err := f.Close(); err != nil {
+		return buildStats{}, err
+	}
+	if err := os.Rename(f.Name(), outPath); err != nil {
+		return buildStats{}, err
+	}
+	done = true
+
+	st.path, err = filepath.Abs(outPath)
+	if err != nil {
+		return buildStats{}, err
+	}
+	return st, nil
+}
+
+// writeMarkdownInPlace runs buildMarkdown straight into the existing
+// non-regular file at outPath (a device or FIFO), with no temp file.
+func writeMarkdownInPlace(outPath, base string, selectedRelSlash []string, opts buildOptions) (buildStats, error) {
+	f, err := os.OpenFile(outPath, os.O_WRONLY, 0)
+	if err != nil {
+		return buildStats{}, err
+	}
+	st, err := buildMarkdown(f, base, selectedRelSlash, opts)
+	if err != nil {
+		f.Close()
+		return buildStats{}, err
+	}
+	if err := f.Close(); err != nil {
+		return buildStats{}, err
+	}
+	st.path = outPath
+	return st, nil
+}
+
+// build writes the context (and the -zip bundle) and prints the summary.
+func build(base string, selectedRelSlash []string, opts buildOptions) error {
+	if opts.atRef != "" {
+		// Read everything from a snapshot of the ref instead of the worktree.
+		dir, kept, err := materializeAt(base, opts.atRef, selectedRelSlash)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		base, selectedRelSlash = dir, kept
+	}
+
+	if opts.redactPaths {
+		opts.redact = newPathRedactor()
+	}
+
+	if opts.output == "-" {
+		// The markdown is the only thing on stdout; the summary moves aside.
+		var out io.Writer = os.Stdout
+		var clip bytes.Buffer
+		if opts.clip {
+			out = io.MultiWriter(os.Stdout, &clip)
+		}
+		st, err := buildMarkdown(out, base, selectedRelSlash, opts)
+		if err != nil {
+			return err
+		}
+		printSummary(os.Stderr, st, opts)
+		opts.redact.writeMapping(os.Stderr)
+		if opts.clip {
+			if err := copyToClipboard(clip.String()); err != nil {
+				fmt.Fprintf(os.Stderr, "mkctx: -clip: %v\n", err)
+			}
+		}
+		return nil
+	}
+
+	outPath, err := outputPath(opts)
+	if err != nil {
+		return err
+	}
+	st, err := writeMarkdownFile(outPath, base, selectedRelSlash, opts)
+	if err != nil {
+		return err
+	}
+	printSummary(os.Stdout, st, opts)
+	opts.redact.writeMapping(os.Stderr)
+	if opts.copyPath {
+		if err := copyToClipboard(st.path); err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -copy-path: %v\n", err)
+		}
+	}
+	if opts.clip {
+		if err := copyFileToClipboard(st.path); err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -clip: %v\n", err)
+		}
+	}
+
+	if opts.zipPath != "" {
+		zipAbs, zipSize, err := writeZip(withSuffix(opts.zipPath, opts.nameSuffix), base, selectedRelSlash, st.path, opts.redact)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("zip=%s\nzip_bytes=%d\n", zipAbs, zipSize)
+	}
+	return nil
+}
+
+// printSummary reports the path (if the markdown went to a file), size
+// and token estimate to w.
+func printSummary(w io.Writer, st buildStats, opts buildOptions) {
+	if st.path != "" {
+		fmt.Fprintln(w, st.path)
+	}
+	fmt.Fprintf(w, "bytes=%d\ntokens=%d\n", st.size, st.tokens)
+	if opts.breakdown {
+		fmt.Fprintf(w, "content_tokens=%d\noverhead_tokens=%d\n", st.contentTokens, st.overheadTokens())
+	}
+	if opts.dedup {
+		fmt.Fprintf(w, "deduped=%d\n", st.deduped)
+	}
+	if opts.maxTokens > 0 && st.tokens > opts.maxTokens {
+		fmt.Fprintf(os.Stderr, "mkctx: %d tokens, %d over the budget of %d\n", st.tokens, st.tokens-opts.maxTokens, opts.maxTokens)
+	}
+}
+
+// logIncluded is the -v progress line for a file that was just written to
+// the markdown: its size on disk and path.
+func logIncluded(base, relSlash string, opts buildOptions) {
+	if !opts.verbose {
+		return
+	}
+	var size int64
+	if st, err := os.Stat(filepath.Join(base, filepath.FromSlash(relSlash))); err == nil {
+		size = st.Size()
+	}
+	fmt.Fprintf(os.Stderr, "%10d  %s\n", size, relSlash)
+}
+
+// warnGarbled is the -check-encoding report for one file.
+func warnGarbled(relSlash string) {
+	fmt.Fprintf(os.Stderr, "mkctx: %s: invalid UTF-8 or replacement characters, may embed as garbage\n", relSlash)
+}
+
+// unwrapPathError drops the path from *fs.PathError, for places that
+// already show which file it is about.
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+// fail reports an error that stopped the run and exits with status 1.
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "mkctx: %v\n", err)
+	os.Exit(1)
+}
+
+// fatalf reports a usage problem and exits with status 2; unlike fail these
+// are the user's to fix.
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "mkctx: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+func main() {
+	allowBinary := flag.Bool("b", false, "allow selecting binary files (use `file <relpath>` in output)")
+	binarySample := flag.Int64("binary-sample", defaultBinarySample, "how many leading `bytes` binary detection looks at")
+	binaryFull := flag.Bool("binary-full", false, "scan whole files for binary detection (slower, catches binary data after a text header)")
+	atRef := flag.String("at", "", "embed selected files as of this git `ref` instead of the working tree")
+	noVendor := flag.Bool("no-vendor", true, "hide vendored dependency directories (see -vendor-dirs); -no-vendor=false shows them")
+	noMinified := flag.Bool("no-minified", false, "leave out minified/bundled files (a line longer than -minified-line)")
+	minifiedLine := flag.Int("minified-line", defaultMinifiedLine, "with -no-minified, line length in `bytes` from which a file counts as minified")
+	var maxSize byteSize
+	flag.Var(&maxSize, "max-size", "leave out files larger than this `size` (bytes, or with K, M, G suffix, e.g. 2M; default unlimited)")
+	vendorDirs := flag.String("vendor-dirs", defaultVendorDirs, "comma-separated directory `names` hidden by -no-vendor")
+	grep := flag.String("grep", "", "only show files matching the regular expression `re`")
+	grepMin := flag.Int("grep-min", 1, "with -grep, only files with at least `N` matches")
+	diffRef := flag.String("diff", "", "only show files changed since git `ref` (working tree included), with +added -deleted line counts")
+	lastCommits := flag.Int("last-commits", 0, "only show files changed in the last `N` commits (git mode)")
+	strict := flag.Bool("strict", false, "fail listing matched binary files instead of silently dropping them (without -b)")
+	fps := flag.Int("fps", 60, "maximum redraws per second (1-120); held Up/Down keys move the cursor once per frame, so lower it if holding a key lags on huge trees")
+	mouse := flag.Bool("mouse", false, "enable mouse: click toggles, right-click selects a range from the last click")
+	showLines := flag.Bool("lines", false, "show line counts in the tree (directories: totals)")
+	showEmptyDirs := flag.Bool("show-empty-dirs", false, "also show directories without listed files (fs mode)")
+	filter := flag.String("filter", "", "start with the tree filtered to paths containing `query`")
+	indent := flag.Int("indent", 2, "spaces per depth level in the tree")
+	guides := flag.Bool("guides", false, "draw tree guides (├─ └─ │) instead of plain indentation")
+	noAgeColors := flag.Bool("no-age-colors", false, "don't tint recently modified files in the tree")
+	verify := flag.String("verify", "", "check that every path in a selection `file` (JSON array) is still selectable, then exit")
+	order := flag.String("order", orderPath, "default output order: path or size-desc")
+	allSets := flag.Bool("all-sets", false, "on build, write one file per non-empty selection set (s 1-9)")
+	estimate := flag.Bool("estimate", false, "print size and token estimate of all listed files and exit")
+	dumpTree := flag.Bool("dump-tree", false, "print the file tree as JSON and exit")
+	list := flag.Bool("list", false, "print the file tree as indented text and exit")
+	maxDepth := flag.Int("depth", 0, "with -list or -dump-tree, stop at directories `N` levels down and just count their files (0 = all)")
+	var recurseDirs stringList
+	flag.Var(&recurseDirs, "recurse", "build without the TUI from every file under this `dir` (repeatable, combines with -select)")
+	exclude := flag.String("exclude", "", "leave out selected files matching comma-separated glob `patterns` (** matches any directories)")
+	headerBase := flag.String("header-base", "", "show file headers relative to this repo-relative `dir` (e.g. services/foo), reading files as usual")
+	selectPatterns := flag.String("select", "", "build without the TUI from comma-separated glob `patterns` (** matches any directories)")
+	stdin := flag.Bool("stdin", false, "build without the TUI from newline-separated base-relative paths on stdin (e.g. git diff --name-only)")
+	applyTree := flag.String("apply-tree", "", "build from a -dump-tree JSON `file` with \"selected\" flags set, skipping the TUI")
+	diffSelection := flag.String("diff-selection", "", "with a second selection file argument: list added/removed paths and exit")
+	redactPaths := flag.Bool("redact-paths", false, "replace directory names in the output with dir1, dir2, ... (mapping on stderr)")
+	resume := flag.Bool("resume", false, "record progress while building, and continue an interrupted build of the same command and selection")
+	clip := flag.Bool("clip", false, "also copy the generated markdown to the clipboard")
+	copyPath := flag.Bool("copy-path", false, "copy the generated markdown's absolute path to the clipboard")
+	tokenRatio := flag.Float64("token-ratio", 4, "bytes per token for estimates")
+	tokenRatioList := flag.String("token-ratios", "", "per file type bytes per token, e.g. `md=3,go=3.5` (extensions or language names)")
+	maxTokens := flag.Int64("max-tokens", 0, "warn when the output is over this many `tokens` (the status line shows the budget)")
+	modelName := flag.String("model", "", "set -max-tokens (and -tokenizer, if public) for this `model`, e.g. gpt-4o or claude-3.5")
+	tokenizerName := flag.String("tokenizer", "", "count tokens with this BPE `encoding` (cl100k_base, o200k_base) instead of bytes/4; downloaded once")
+	splitAt := flag.Int("split-at", 0, "split long files into consecutive fenced blocks of at most `N` lines")
+	dedup := flag.Bool("dedup", false, "embed identical files once; later copies get a header pointing to the first")
+	numbers := flag.Bool("numbers", false, "prefix each embedded line with its line number")
+	markers := flag.Bool("markers", false, "for files with mkctx:start / mkctx:end comment lines, embed only the lines between them")
+	verbose := flag.Bool("v", false, "log each file (size and path) to stderr as it is written")
+	restore := flag.Bool("restore", false, "start with the selection last built from this directory (any branch)")
+	remember := flag.Bool("remember", false, "start with the selection last built on this git branch (kept in .mkctx/selection.json)")
+	output := flag.String("o", "", "write the markdown to this `path` instead of .mkctx/ (\"-\" for stdout)")
+	zipPath := flag.String("zip", "", "also write a zip `file` with the selected files, the markdown and a manifest")
+	watch := flag.Bool("watch", false, "keep running and rebuild whenever a selected file changes")
+	stripANSI := flag.Bool("strip-ansi", false, "remove ANSI escape sequences from embedded text")
+	outline := flag.Bool("outline", false, "embed only declarations and signatures for Go files")
+	elideLong := flag.Int("elide-long", 0, "Go files: keep only the first `N` lines of longer function bodies")
+	hashes := flag.Bool("hashes", false, "append each file's SHA-256 to its section header")
+	since := flag.String("since", "", "only show files that changed since a previous context `file` written with -hashes (implies -hashes)")
+	ignoreWS := flag.Bool("ignore-whitespace", false, "hash whitespace-collapsed content (sha256-ws:), so -since ignores reformatting")
+	fenceTmpl := flag.String("fence-info", "", "opening fence info string `template` with {lang} and {path} (default: just the language)")
+	depGraph := flag.Bool("depgraph", false, "prepend a Mermaid graph of imports between selected Go packages")
+	importMap := flag.Bool("import-map", false, "prepend which imports of selected Go/JS/TS files resolve to other selected files")
+	breakdown := flag.Bool("breakdown", false, "report content vs overhead (headers, fences, ...) tokens separately")
+	nameFormat := flag.String("name-format", defaultNameFormat, "output file name: Go time layout, or template with {date}, {time}, {branch}")
+	mergeLang := flag.Bool("merge-lang", false, "put adjacent files of the same language into one code fence")
+	guessLang := flag.Bool("guess-lang", false, "guess the fence language from content for unknown extensions")
+	collapseBlanks := flag.Bool("collapse-blanks", false, "squeeze runs of blank lines in embedded text into one")
+	detectLang := flag.Bool("detect-lang", false, "start the output with a <!-- primary: lang --> comment")
+	collapsible := flag.Bool("collapsible", false, "wrap each file in a <details> block with the path as summary")
+	singleFence := flag.Bool("single-fence", false, "wrap all files in one code block, separated by file: comments")
+	checkEncoding := flag.Bool("check-encoding", false, "warn about files with invalid UTF-8 or U+FFFD replacement characters")
+	perFileTokens := flag.Bool("per-file-tokens", false, "show an estimated token count in each file's header")
+	describe := flag.Bool("describe", false, "put a one-line description (package doc, first comment or line) above each file")
+	listExcluded := flag.Bool("list-excluded", false, "append a section listing the files that were not selected")
+	stats := flag.Bool("stats", false, "start the output with tables of file count, size, lines, languages and the largest files")
+	treeOverview := flag.Bool("tree", false, "start the output with an ASCII tree of the selected files")
+	dirListings := flag.Bool("dir-listings", false, "list every entry of each directory that contains a selected file")
+	flag.Parse()
+
+	if *diffSelection != "" {
+		if flag.NArg() != 1 {
+			fatalf("usage: mkctx -diff-selection old.json new.json")
+		}
+		diffSelections(readSelection(*diffSelection), readSelection(flag.Arg(0)))
+		return
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fail(err)
+	}
+
+	base, inRepo := findGitRoot(cwd)
+	if !inRepo {
+		base = cwd
+	}
+
+	switch *order {
+	case orderPath, orderSizeDesc:
+	default:
+		fatalf("-order: unknown order %q (want %s or %s)", *order, orderPath, orderSizeDesc)
+	}
+
+	var branch string
+	if inRepo && strings.Contains(*nameFormat, "{branch}") {
+		branch = gitBranch(base)
+	}
+	if *fps < 1 || *fps > 120 {
+		fatalf("-fps must be between 1 and 120")
+	}
+	if *indent < 0 {
+		fatalf("-indent must not be negative")
+	}
+	if *resume && (*output == "-" || *singleFence) {
+		fatalf("-resume needs a markdown file with per-file sections (not -o - or -single-fence)")
+	}
+	if *clip && *copyPath {
+		fatalf("-clip and -copy-path both use the clipboard; pick one")
+	}
+	if *output == "-" {
+		switch {
+		case *zipPath != "":
+			fatalf("-zip needs a markdown file, not -o -")
+		case *copyPath:
+			fatalf("-copy-path needs a markdown file, not -o -")
+		case *watch:
+			fatalf("-watch can't be combined with -o -")
+		}
+	} else if *output != "" {
+		// Relative to where mkctx was started, not the repo root.
+		abs, err := filepath.Abs(*output)
+		if err != nil {
+			fail(err)
+		}
+		*output = abs
+	}
+	ratios, err := parseTokenRatios(*tokenRatio, *tokenRatioList)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *modelName != "" {
+		info, err := lookupModel(*modelName)
+		if err != nil {
+			fatalf("-model: %v", err)
+		}
+		if *maxTokens == 0 {
+			*maxTokens = info.window
+		}
+		if *tokenizerName == "" {
+			*tokenizerName = info.encoding
+		}
+	}
+	if *maxTokens < 0 {
+		fatalf("-max-tokens must not be negative")
+	}
+	var tokenizer *tiktoken.Tiktoken
+	if *tokenizerName != "" {
+		if err := checkEncodingName(*tokenizerName); err != nil {
+			fatalf("-tokenizer: %v", err)
+		}
+		// Before the TUI, so a failed download shows up front.
+		if tokenizer, err = loadTokenizer(*tokenizerName); err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -tokenizer %s: %v; estimating from bytes instead\n", *tokenizerName, err)
+		}
+	}
+	if *numbers && (*outline || *elideLong > 0) {
+		fatalf("-numbers refers to lines of the file, which -outline and -elide-long replace")
+	}
+	if *splitAt < 0 {
+		fatalf("-split-at must not be negative")
+	}
+	if *splitAt > 0 && (*singleFence || *mergeLang) {
+		fatalf("-split-at works on files in their own sections, not with -single-fence or -merge-lang")
+	}
+	if *dedup && (*singleFence || *mergeLang) {
+		fatalf("-dedup works on files in their own sections, not with -single-fence or -merge-lang")
+	}
+	if *collapsible && *singleFence {
+		fatalf("-collapsible and -single-fence don't mix (there is only one section)")
+	}
+	if *minifiedLine < 1 {
+		fatalf("-minified-line must be positive")
+	}
+	if *binarySample < 1 {
+		fatalf("-binary-sample must be positive")
+	}
+
+	if _, err := outputName(*nameFormat, time.Now(), branch); err != nil {
+		fatalf("%v", err)
+	}
+
+	//startRelOS := "." // FIXME
 	startRelSlash := "."
 	if inRepo {
 		rel, err := filepath.Rel(base, cwd)
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 		//startRelOS = rel
 		startRelSlash = filepath.ToSlash(rel)
 	}
 
-	// Build file list (base-relative slash paths), restricted to current directory.
-	var files []string
-	if inRepo {
-		files = gitListFiles(base, startRelSlash)
-	} else {
-		files = walkFiles(base)
+	// Build file list (base-relative slash paths), restricted to current
+	// directory or the path arguments.
+	roots := []startRoot{{relSlash: startRelSlash, dir: true}}
+	if flag.NArg() > 0 {
+		if roots, err = resolveRoots(base, flag.Args()); err != nil {
+			fail(err)
+		}
+		if len(roots) == 1 && roots[0].dir {
+			startRelSlash = roots[0].relSlash // as if started there
+		} else {
+			startRelSlash = rootsKey(roots)
+		}
+	}
+	files, err := listRoots(base, inRepo, roots)
+	if err != nil {
+		fail(err)
+	}
+
+	if *noVendor {
+		files = dropVendored(files, *vendorDirs)
+	}
+	if maxSize > 0 {
+		var dropped int
+		files, dropped = dropOversize(base, files, int64(maxSize))
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "mkctx: -max-size: left out %d files over %s\n", dropped, maxSize.String())
+		}
+	}
+
+	if *atRef != "" {
+		if !inRepo {
+			fatalf("-at needs a git repository")
+		}
+		if err := gitVerifyCommit(base, *atRef); err != nil {
+			fatalf("-at: %v", err)
+		}
+	}
+
+	var diffStats map[string]diffStat
+	if *lastCommits > 0 {
+		if !inRepo {
+			fatalf("-last-commits needs a git repository")
+		}
+		if *diffRef != "" {
+			fatalf("-last-commits and -diff don't mix")
+		}
+		if diffStats, err = gitChangedFiles(base, fmt.Sprintf("HEAD~%d..HEAD", *lastCommits)); err != nil {
+			fatalf("-last-commits: %v", err)
+		}
+		files = keepOnly(files, slices.Collect(maps.Keys(diffStats)))
+	}
+	if *diffRef != "" {
+		if !inRepo {
+			fatalf("-diff needs a git repository")
+		}
+		if err := gitVerifyCommit(base, *diffRef); err != nil {
+			fatalf("-diff: %v", err)
+		}
+		if diffStats, err = gitChangedFiles(base, *diffRef); err != nil {
+			fatalf("-diff: %v", err)
+		}
+		files = keepOnly(files, slices.Collect(maps.Keys(diffStats)))
+	}
+
+	if *since != "" {
+		prev, err := readSinceHashes(*since)
+		if err != nil {
+			fatalf("-since: %v", err)
+		}
+		var dropped, raw int
+		files, dropped, raw = dropUnchanged(base, files, prev, *ignoreWS)
+		if raw > 0 {
+			fmt.Fprintf(os.Stderr, "mkctx: -ignore-whitespace: %s has plain sha256 hashes, compared %d files byte for byte\n", *since, raw)
+		}
+		fmt.Fprintf(os.Stderr, "mkctx: -since: left out %d unchanged files\n", dropped)
+		*hashes = true
 	}
 
 	// Filter binaries from selection unless -b.
+	sample := *binarySample
+	if *binaryFull {
+		sample = 0
+	}
+	var binaries []string
 	if !*allowBinary {
-		dst := files[:0]
+		files, binaries = filterBinaries(base, files, sample)
+	}
+
+	if *noMinified {
+		var dropped int
+		files, dropped = dropMinified(base, files, *minifiedLine)
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "mkctx: -no-minified: left out %d minified files\n", dropped)
+		}
+	}
+
+	if *grep != "" {
+		re, err := regexp.Compile(*grep)
+		if err != nil {
+			fatalf("-grep: %v", err)
+		}
+		if *grepMin < 1 {
+			fatalf("-grep-min must be at least 1")
+		}
+		files = grepFiles(base, files, re, *grepMin)
+	}
+
+	if *verify != "" {
+		if verifySelection(base, readSelection(*verify), files, binaries) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var excludePatterns []string
+	if *exclude != "" {
+		var err error
+		if excludePatterns, err = parseGlobs(*exclude); err != nil {
+			fatalf("-exclude: %v", err)
+		}
+	}
+
+	var globs []string
+	if *selectPatterns != "" {
+		var err error
+		if globs, err = parseGlobs(*selectPatterns); err != nil {
+			fatalf("-select: %v", err)
+		}
+	}
+	for _, dir := range recurseDirs {
+		pattern, err := recursePattern(base, dir)
+		if err != nil {
+			fatalf("-recurse: %v", err)
+		}
+		globs = append(globs, pattern)
+	}
+	if globs != nil && *strict {
+		// Only binaries the patterns ask for, and -exclude leaves, matter
+		// to -strict then.
+		var matched []string
+		for _, relSlash := range binaries {
+			if matchAnyGlob(globs, relSlash) && !matchAnyGlob(excludePatterns, relSlash) {
+				matched = append(matched, relSlash)
+			}
+		}
+		failStrict(matched)
+	}
+
+	if *estimate {
+		var total int64
+		var tokens float64
 		for _, relSlash := range files {
-			abs := filepath.Join(base, filepath.FromSlash(relSlash))
-			if isBinary(abs) {
-				continue
+			st, err := os.Stat(filepath.Join(base, filepath.FromSlash(relSlash)))
+			if err != nil {
+				fail(err)
 			}
-			dst = append(dst, relSlash)
+			total += st.Size()
+			tokens += float64(st.Size()) / ratios.forFile(relSlash)
+		}
+		fmt.Printf("files=%d\nbytes=%d\ntokens=%d\n", len(files), total, int64(math.Ceil(tokens)))
+		if *maxTokens > 0 && int64(math.Ceil(tokens)) > *maxTokens {
+			fmt.Fprintf(os.Stderr, "mkctx: over the budget of %d tokens\n", *maxTokens)
 		}
-		files = dst
+		return
 	}
 
-	root := buildTree(startRelSlash, files)
+	var root *node
+	if len(roots) == 1 && roots[0].dir {
+		root = buildTree(startRelSlash, files)
+	} else {
+		root = buildForest(roots, files)
+	}
+	if *showEmptyDirs {
+		if inRepo {
+			fatalf("-show-empty-dirs only works outside git repositories")
+		}
+		if flag.NArg() > 0 {
+			fatalf("-show-empty-dirs doesn't take path arguments")
+		}
+		var skip map[string]bool
+		if *noVendor {
+			skip = vendorNames(*vendorDirs)
+		}
+		dirs, err := findEmptyDirs(base, files, skip)
+		if err != nil {
+			fail(err)
+		}
+		addEmptyDirs(root, dirs)
+	}
+	if *maxDepth < 0 {
+		fatalf("-depth must not be negative")
+	}
+	if *dumpTree {
+		if err := dumpTreeJSON(os.Stdout, root, *maxDepth); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if *list {
+		if err := listTree(os.Stdout, root, *maxDepth); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	opts := buildOptions{
+		allowBinary:    *allowBinary,
+		binarySample:   sample,
+		stripANSI:      *stripANSI,
+		collapseBlanks: *collapseBlanks,
+		outline:        *outline,
+		depGraph:       *depGraph,
+		importMap:      *importMap,
+		breakdown:      *breakdown,
+		mergeLang:      *mergeLang,
+		guessLang:      *guessLang,
+		detectLang:     *detectLang,
+		treeOverview:   *treeOverview,
+		stats:          *stats,
+		singleFence:    *singleFence,
+		collapsible:    *collapsible,
+		dirListings:    *dirListings,
+		listExcluded:   *listExcluded,
+		elideLong:      *elideLong,
+		hashes:         *hashes,
+		ignoreWS:       *ignoreWS,
+		fenceInfo:      *fenceTmpl,
+		checkEncoding:  *checkEncoding,
+		perFileTokens:  *perFileTokens,
+		describe:       *describe,
+		tokenizer:      tokenizer,
+		ratios:         ratios,
+		maxTokens:      *maxTokens,
+		verbose:        *verbose,
+		markers:        *markers,
+		numbers:        *numbers,
+		splitAt:        *splitAt,
+		dedup:          *dedup,
+		nameFormat:     *nameFormat,
+		zipPath:        *zipPath,
+		outDir:         filepath.Join(base, ".mkctx"),
+		output:         *output,
+		atRef:          *atRef,
+		redactPaths:    *redactPaths,
+		headerBase:     path.Clean(filepath.ToSlash(*headerBase)),
+		copyPath:       *copyPath,
+		resume:         *resume,
+		clip:           *clip,
+		exclude:        excludePatterns,
+		branch:         branch,
+		tree:           root,
+	}
+
+	statTree(base, root)
+	attachDiffStats(root, diffStats)
+
+	if *output == "" {
+		if err := checkOutDir(opts.outDir); err != nil {
+			fail(err)
+		}
+	}
+
+	if *stdin {
+		paths, err := readPathList(os.Stdin)
+		if err != nil {
+			fail(err)
+		}
+		if *strict {
+			failStrict(dropGlobs(listedBinaries(binaries, paths), excludePatterns))
+		}
+		selected := selectPaths(root, base, paths, *order)
+		if len(selected) == 0 {
+			fatalf("-stdin: no usable paths")
+		}
+		selected = excludeSelected(selected, opts)
+		if err := build(base, selected, opts); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if *applyTree != "" {
+		selected := applyTreeJSON(root, readTreeJSON(*applyTree), *order)
+		selected = excludeSelected(selected, opts)
+		if err := build(base, selected, opts); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if globs != nil {
+		selected := selectGlobs(root, globs, *order)
+		if len(selected) == 0 {
+			fatalf("no files match -select/-recurse")
+		}
+		selected = excludeSelected(selected, opts)
+		if err := build(base, selected, opts); err != nil {
+			fail(err)
+		}
+		return
+	}
 
 	m := newModel(root, base, inRepo, *allowBinary)
-	p := tea.NewProgram(m, tea.WithAltScreen())
+	var memKey string
+	if *remember {
+		memKey = memoryKey(base, inRepo)
+		mem, err := readMemory(opts.outDir, memoryFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -remember: %v\n", err)
+		}
+		recallSelection(root, mem[memKey])
+		m.selectedCount = countSelected(root)
+	}
+	if *restore {
+		mem, err := readMemory(opts.outDir, lastSelectionFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -restore: %v\n", err)
+		}
+		last := mem[startRelSlash]
+		if found := recallSelection(root, last); found < len(last) {
+			fmt.Fprintf(os.Stderr, "mkctx: -restore: %d of %d paths are gone\n", len(last)-found, len(last))
+		}
+		m.selectedCount = countSelected(root)
+	}
+	m.orderBy = *order
+	m.opts = opts
+	m.maxTokens = *maxTokens
+	if *showLines {
+		countTreeLines(base, root)
+		m.showLines = true
+	}
+	m.ageColors = !*noAgeColors
+	m.guides = *guides
+	m.indent = *indent
+	if *filter != "" {
+		m.filtering = true
+		m.setFilter(*filter)
+	}
+	m.frame = time.Second / time.Duration(*fps)
+	progOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithFPS(*fps)}
+	if *mouse {
+		progOpts = append(progOpts, tea.WithMouseCellMotion())
+	}
+	if *output == "-" {
+		// Keep stdout clean for the markdown, e.g. `mkctx -o - | pbcopy`.
+		progOpts = append(progOpts, tea.WithOutput(os.Stderr))
+	}
+	p := tea.NewProgram(m, progOpts...)
 
 	final, err := p.Run()
 	if err != nil {
-		panic(err)
+		fail(err)
 	}
 
 	fm := final.(model)
@@ -857,9 +3094,45 @@ func main() {
 		return
 	}
 
-	if fm.confirmed {
-		selected := fm.selectedFiles()
-		outAbs, size, tokens := buildMarkdown(fm.base, selected, fm.allowBinary)
-		fmt.Printf("%s\nbytes=%d\ntokens=%d\n", outAbs, size, tokens)
+	if !fm.confirmed {
+		return
+	}
+
+	if *allSets {
+		sets := fm.selectionSets()
+		keys := make([]int, 0, len(sets))
+		for k := range sets {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+		for _, k := range keys {
+			setOpts := opts
+			setOpts.nameSuffix = fmt.Sprintf("-set%d", k)
+			if err := build(base, excludeSelected(sets[k], opts), setOpts); err != nil {
+				fail(err)
+			}
+		}
+		return
+	}
+
+	selected := fm.selectedFiles()
+	if *remember {
+		if err := rememberSelection(opts.outDir, memoryFile, memKey, selected); err != nil {
+			fmt.Fprintf(os.Stderr, "mkctx: -remember: %v\n", err)
+		}
+	}
+	// Saved on every confirm, for -restore.
+	if err := rememberSelection(opts.outDir, lastSelectionFile, startRelSlash, slices.Sorted(slices.Values(selected))); err != nil {
+		fmt.Fprintf(os.Stderr, "mkctx: %s: %v\n", lastSelectionFile, err)
+	}
+	selected = excludeSelected(selected, opts)
+	if err := build(base, selected, opts); err != nil {
+		fail(err)
+	}
+
+	if *watch && len(selected) > 0 {
+		if err := watchAndRebuild(base, selected, opts); err != nil {
+			fail(err)
+		}
 	}
 }
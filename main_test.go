@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// oddNames are file names git quotes or escapes unless told not to.
+var oddNames = []string{
+	"with space.txt",
+	"dir with space/inner file.go",
+	"ünïcødé/日本語.md",
+	`quote"d.txt`,
+	"apos'trophe.txt",
+	"glob[1]*?.txt",
+}
+
+func TestGitListFilesOddNames(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	base := t.TempDir()
+	if out, err := exec.Command("git", "-C", base, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v: %s", err, out)
+	}
+	for _, name := range oddNames {
+		abs := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte("x\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One tracked, the rest untracked: both come from ls-files.
+	if out, err := exec.Command("git", "-C", base, "add", "--", oddNames[0]).CombinedOutput(); err != nil {
+		t.Fatalf("git add: %v: %s", err, out)
+	}
+
+	files, err := gitListFiles(base, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Sorted(slices.Values(oddNames))
+	if got := slices.Sorted(slices.Values(files)); !slices.Equal(got, want) {
+		t.Fatalf("gitListFiles:\n got %q\nwant %q", got, want)
+	}
+
+	sub, err := gitListFiles(base, "dir with space")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sub, []string{"dir with space/inner file.go"}) {
+		t.Fatalf("gitListFiles(dir with space) = %q", sub)
+	}
+
+	root := buildTree(".", files)
+	var got []string
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			got = append(got, filepath.ToSlash(n.relBase))
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("buildTree files:\n got %q\nwant %q", got, want)
+	}
+}
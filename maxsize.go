@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a -max-size flag value: bytes, optionally with a K, M or G
+// suffix (binary multiples; a trailing B is allowed). Zero means no limit.
+type byteSize int64
+
+func (s *byteSize) String() string {
+	if *s == 0 {
+		return ""
+	}
+	return humanBytes(int64(*s))
+}
+
+func (s *byteSize) Set(v string) error {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(v)), "B")
+	mult := int64(1)
+	if k := len(num); k > 0 {
+		switch num[k-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			num = num[:k-1]
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("bad size %q (want e.g. 500K or 2M)", v)
+	}
+	*s = byteSize(n * float64(mult))
+	return nil
+}
+
+// dropOversize removes files larger than limit bytes (generated dumps and
+// the like) and returns how many it dropped. Files that can't be stat'ed are
+// kept; filterBinaries reports those.
+func dropOversize(base string, files []string, limit int64) (kept []string, dropped int) {
+	kept = files[:0:0]
+	for _, relSlash := range files {
+		st, err := os.Stat(filepath.Join(base, filepath.FromSlash(relSlash)))
+		if err == nil && st.Size() > limit {
+			dropped++
+			continue
+		}
+		kept = append(kept, relSlash)
+	}
+	return kept, dropped
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Selection memory: selections built from the TUI are kept in .mkctx as a
+// JSON object of path lists.
+//
+// -remember reads selection.json, keyed by git branch, so switching
+// branches brings back that branch's selection:
+//
+//	{"main": ["README.md"], "feature/auth": ["internal/auth/a.go"]}
+//
+// last-selection.json is always written on confirm, keyed by the directory
+// mkctx was started in (sorted paths); -restore reads it.
+
+const (
+	memoryFile        = "selection.json"
+	lastSelectionFile = "last-selection.json"
+)
+
+// defaultMemoryKey is used outside git repositories and on a detached HEAD.
+const defaultMemoryKey = "default"
+
+func memoryKey(base string, inRepo bool) string {
+	if inRepo {
+		if b := gitBranch(base); b != "" {
+			return b
+		}
+	}
+	return defaultMemoryKey
+}
+
+// readMemory returns all selections remembered in the named file in outDir;
+// a missing file is an empty memory.
+func readMemory(outDir, name string) (map[string][]string, error) {
+	mem := make(map[string][]string)
+	data, err := os.ReadFile(filepath.Join(outDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return mem, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &mem); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return mem, nil
+}
+
+// rememberSelection stores files as the selection for key in the named file.
+func rememberSelection(outDir, name, key string, files []string) error {
+	mem, err := readMemory(outDir, name)
+	if err != nil {
+		return err
+	}
+	mem[key] = files
+	data, err := json.MarshalIndent(mem, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(outDir, name), append(data, '\n'), 0o644)
+}
+
+// recallSelection selects the remembered paths that are still selectable in
+// the tree and returns how many were; the rest (deleted, renamed, now
+// ignored) are forgotten.
+func recallSelection(root *node, paths []string) int {
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
+	}
+	found := 0
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir && n.selectable() && want[filepath.ToSlash(n.relBase)] {
+			n.selected = true
+			found++
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+	return found
+}
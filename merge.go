@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// commentLine renders text as a single-line comment in lang, or returns ""
+// if we don't know the comment syntax (such files are never merged).
+func commentLine(lang, text string) string {
+	switch lang {
+	case "go", "javascript", "typescript", "java", "kotlin", "rust", "c", "cpp", "csharp", "scss", "php":
+		return "// " + text
+	case "python", "ruby", "bash", "zsh", "yaml", "toml", "make", "dockerfile", "ini":
+		return "# " + text
+	case "sql":
+		return "-- " + text
+	case "html", "xml", "markdown":
+		return "<!-- " + text + " -->"
+	case "css":
+		return "/* " + text + " */"
+	default:
+		return ""
+	}
+}
+
+// mergeGroup returns the leading run of files that can share one fence with
+// files[0]: same language, text, and a known comment syntax for separators.
+func mergeGroup(base string, files []string, opts buildOptions) ([]string, error) {
+	lang := languageFor(filepath.FromSlash(files[0]))
+	if commentLine(lang, "") == "" {
+		return files[:1], nil
+	}
+	n := 1
+	for n < len(files) {
+		if languageFor(filepath.FromSlash(files[n])) != lang {
+			break
+		}
+		bin, err := isBinaryIn(base, files[n], opts)
+		if err != nil {
+			return nil, err
+		}
+		if bin {
+			break
+		}
+		n++
+	}
+	return files[:n], nil
+}
+
+// writeMergedSection embeds several same-language files in a single fence,
+// each preceded by a "file: path" comment.
+func writeMergedSection(w io.Writer, content *contentCounter, base string, group []string, opts buildOptions) error {
+	lang := languageFor(filepath.FromSlash(group[0]))
+
+	maxRun := 0
+	for _, relSlash := range group {
+		run, err := maxRunInContent(filepath.Join(base, filepath.FromSlash(relSlash)), opts)
+		if err != nil {
+			return err
+		}
+		maxRun = max(maxRun, run)
+		maxRun = max(maxRun, maxRunByteInString(displayPath(relSlash, opts), '`'))
+	}
+	fence := fenceForContent(maxRun)
+
+	titles := make([]string, len(group))
+	for i, relSlash := range group {
+		title, err := sectionTitle(base, relSlash, opts)
+		if err != nil {
+			return err
+		}
+		titles[i] = title
+	}
+	sectionStart(w, strings.Join(titles, ", "), opts)
+	if opts.describe {
+		for _, relSlash := range group {
+			if d := describeFile(filepath.Join(base, filepath.FromSlash(relSlash))); d != "" {
+				fmt.Fprintf(w, "> %s: %s\n", displayPath(relSlash, opts), d)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	redacted := make([]string, len(group))
+	for i, relSlash := range group {
+		redacted[i] = displayPath(relSlash, opts)
+	}
+	fmt.Fprintln(w, fence+fenceInfo(opts, lang, strings.Join(redacted, ",")))
+	for _, relSlash := range group {
+		fmt.Fprintln(w, commentLine(lang, "file: "+displayPath(relSlash, opts)))
+		garbled, err := content.copyFile(content, filepath.Join(base, filepath.FromSlash(relSlash)), opts)
+		if err != nil {
+			return err
+		}
+		if garbled {
+			warnGarbled(relSlash)
+		}
+		logIncluded(base, relSlash, opts)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, fence)
+	fmt.Fprintln(w)
+	sectionEnd(w, opts)
+	return nil
+}
+
+// separatorLine marks the start of a file inside a shared fence.
+func separatorLine(relSlash string) string {
+	lang := languageFor(filepath.FromSlash(relSlash))
+	if line := commentLine(lang, "file: "+relSlash); line != "" {
+		return line
+	}
+	return "# file: " + relSlash
+}
+
+// writeSingleFence puts every selected file into one outer fence, separated
+// by "file: path" comments. Binary files contribute their `file` description.
+func writeSingleFence(w io.Writer, content *contentCounter, base string, selectedRelSlash []string, opts buildOptions) error {
+	maxRun := 0
+	descs := make(map[string][]byte)
+	for _, relSlash := range selectedRelSlash {
+		maxRun = max(maxRun, maxRunByteInString(separatorLine(displayPath(relSlash, opts)), '`'))
+		bin, err := isBinaryIn(base, relSlash, opts)
+		if err != nil {
+			return err
+		}
+		if bin {
+			desc, err := fileDescription(base, relSlash)
+			if err != nil {
+				return err
+			}
+			descs[relSlash] = desc
+			maxRun = max(maxRun, maxRunByteInString(string(desc), '`'))
+			continue
+		}
+		run, err := maxRunInContent(filepath.Join(base, filepath.FromSlash(relSlash)), opts)
+		if err != nil {
+			return err
+		}
+		maxRun = max(maxRun, run)
+	}
+	fence := fenceForContent(maxRun)
+
+	fmt.Fprintln(w, fence)
+	for _, relSlash := range selectedRelSlash {
+		fmt.Fprintln(w, separatorLine(displayPath(relSlash, opts)))
+		if desc, ok := descs[relSlash]; ok {
+			if _, err := content.Write(desc); err != nil {
+				return err
+			}
+		} else {
+			garbled, err := content.copyFile(content, filepath.Join(base, filepath.FromSlash(relSlash)), opts)
+			if err != nil {
+				return err
+			}
+			if garbled {
+				warnGarbled(relSlash)
+			}
+		}
+		logIncluded(base, relSlash, opts)
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, fence)
+	fmt.Fprintln(w)
+	return nil
+}
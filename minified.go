@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// defaultMinifiedLine is the line length (in bytes) from which -no-minified
+// considers a file minified.
+const defaultMinifiedLine = 2000
+
+// dropMinified removes files with a line longer than limit (bundled or
+// minified JS/CSS and the like) and returns how many it dropped. Unreadable
+// files are kept; filterBinaries reports those.
+func dropMinified(base string, files []string, limit int) (kept []string, dropped int) {
+	kept = files[:0:0]
+	for _, relSlash := range files {
+		minified, err := isMinified(filepath.Join(base, filepath.FromSlash(relSlash)), limit)
+		if err == nil && minified {
+			dropped++
+			continue
+		}
+		kept = append(kept, relSlash)
+	}
+	return kept, dropped
+}
+
+// isMinified scans the file for a line longer than limit, stopping at the
+// first one. Files with NUL bytes are binary, not minified (relevant with -b).
+func isMinified(abs string, limit int) (bool, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	line := 0
+	for {
+		n, err := f.Read(buf)
+		for _, c := range buf[:n] {
+			switch c {
+			case '\n':
+				line = 0
+			case 0:
+				return false, nil
+			default:
+				line++
+				if line > limit {
+					return true, nil
+				}
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
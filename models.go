@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// modelInfo is what -model knows about an LLM: its context window in
+// tokens and, where public, the BPE encoding it uses (for -tokenizer).
+type modelInfo struct {
+	name     string
+	window   int64
+	encoding string
+}
+
+// knownModels is the -model table; add rows as models come out.
+var knownModels = []modelInfo{
+	{"gpt-4o", 128_000, "o200k_base"},
+	{"gpt-4o-mini", 128_000, "o200k_base"},
+	{"gpt-4.1", 1_047_576, "o200k_base"},
+	{"o1", 200_000, "o200k_base"},
+	{"o3", 200_000, "o200k_base"},
+	{"gpt-4-turbo", 128_000, "cl100k_base"},
+	{"gpt-4", 8_192, "cl100k_base"},
+	{"gpt-3.5-turbo", 16_385, "cl100k_base"},
+	{"claude-3.5", 200_000, ""},
+	{"claude-3.7", 200_000, ""},
+	{"claude-4", 200_000, ""},
+	{"gemini-1.5-pro", 2_097_152, ""},
+	{"gemini-2.5-pro", 1_048_576, ""},
+}
+
+func lookupModel(name string) (modelInfo, error) {
+	names := make([]string, len(knownModels))
+	for i, m := range knownModels {
+		if strings.EqualFold(m.name, name) {
+			return m, nil
+		}
+		names[i] = m.name
+	}
+	return modelInfo{}, fmt.Errorf("unknown model %q (known: %s)", name, strings.Join(names, ", "))
+}
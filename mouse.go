@@ -0,0 +1,76 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Mouse (with -mouse): left click moves the cursor there and toggles the
+// file (or expands/collapses the directory); right click selects every file
+// between the last left-clicked row and this one. The wheel scrolls.
+
+// rowAt maps a screen row to an index in m.vis, or -1. Row 0 is the status
+// line.
+func (m model) rowAt(y int) int {
+	i := m.offset + y - 1
+	if y < 1 || y > m.viewportHeight() || i >= len(m.vis) {
+		return -1
+	}
+	return i
+}
+
+func (m model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if m.reviewing {
+		return m, nil
+	}
+
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp && msg.Action == tea.MouseActionPress:
+		m.cursor = max(m.cursor-1, 0)
+		m.ensureCursorVisible()
+
+	case msg.Button == tea.MouseButtonWheelDown && msg.Action == tea.MouseActionPress:
+		m.cursor = min(m.cursor+1, len(m.vis)-1)
+		m.ensureCursorVisible()
+
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress:
+		i := m.rowAt(msg.Y)
+		if i < 0 {
+			return m, nil
+		}
+		n := m.vis[i]
+		m.cursor = i
+		m.lastClicked = n
+		if n.isDir {
+			if len(n.children) > 0 {
+				n.expanded = !n.expanded
+				m.vis = m.visible()
+				m.cursor = indexOf(m.vis, n)
+			}
+		} else if n.selectable() {
+			n.selected = !n.selected
+			m.selectedCount = countSelected(m.root)
+		}
+		m.ensureCursorVisible()
+
+	case msg.Button == tea.MouseButtonRight && msg.Action == tea.MouseActionPress:
+		i := m.rowAt(msg.Y)
+		if i < 0 || m.lastClicked == nil {
+			return m, nil
+		}
+		from := indexOf(m.vis, m.lastClicked)
+		if m.vis[from] != m.lastClicked {
+			// Anchor got hidden by a collapse; nothing to range from.
+			return m, nil
+		}
+		lo, hi := min(from, i), max(from, i)
+		for _, n := range m.vis[lo : hi+1] {
+			if n.selectable() {
+				n.selected = true
+			}
+		}
+		m.selectedCount = countSelected(m.root)
+		m.cursor = i
+		m.ensureCursorVisible()
+	}
+	return m, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultNameFormat = "source-context-2006-01-02-15-04-05"
+
+// outputName builds the context file name from format, which is either a Go
+// time layout or, if it contains '{', a template with {date}, {time} and
+// {branch} placeholders. ".md" is appended unless already present.
+func outputName(format string, now time.Time, branch string) (string, error) {
+	var name string
+	if strings.Contains(format, "{") {
+		name = strings.NewReplacer(
+			"{date}", now.Format("2006-01-02"),
+			"{time}", now.Format("15-04-05"),
+			"{branch}", sanitizeName(branch),
+		).Replace(format)
+		if i := strings.IndexAny(name, "{}"); i != -1 {
+			return "", fmt.Errorf("name format %q: unknown placeholder", format)
+		}
+	} else {
+		name = now.Format(format)
+	}
+
+	if !strings.HasSuffix(name, ".md") {
+		name += ".md"
+	}
+	if err := validateName(name); err != nil {
+		return "", fmt.Errorf("name format %q: %w", format, err)
+	}
+	return name, nil
+}
+
+func validateName(name string) error {
+	if name == ".md" || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("file name %q would be hidden or empty", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return fmt.Errorf("file name %q contains %q", name, r)
+		}
+	}
+	return nil
+}
+
+// sanitizeName makes s safe for use inside a file name (branch names like
+// "feature/x" are common).
+func sanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>| `, r) {
+			return '-'
+		}
+		return r
+	}, s)
+}
+
+// gitBranch returns the current branch name, or "" outside a repo or on a
+// detached HEAD.
+func gitBranch(base string) string {
+	out, err := exec.Command("git", "-C", base, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	b := strings.TrimSpace(string(out))
+	if b == "HEAD" {
+		return ""
+	}
+	return b
+}
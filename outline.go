@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+)
+
+// goOutline renders a Go file with all function bodies removed: package
+// clause, imports, declarations and signatures stay, implementations go.
+// ok is false if the file doesn't parse, in which case callers embed it as is.
+func goOutline(abs string) (out []byte, ok bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, abs, nil, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+
+	var bodies []*ast.BlockStmt
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		bodies = append(bodies, fd.Body)
+		fd.Body = nil
+	}
+
+	// Comments inside removed bodies would otherwise be printed loose.
+	comments := f.Comments[:0]
+	for _, cg := range f.Comments {
+		inside := false
+		for _, b := range bodies {
+			if cg.Pos() >= b.Lbrace && cg.End() <= b.Rbrace {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			comments = append(comments, cg)
+		}
+	}
+	f.Comments = comments
+
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&buf, fset, f); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// goElide keeps Go function bodies up to maxLines lines and replaces the rest
+// of longer ones with an "elided" marker. Signatures, comments and short
+// functions are untouched. ok is false if the file doesn't parse.
+func goElide(abs string, maxLines int) (out []byte, ok bool) {
+	src, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, false // openContent reports it when opening the file
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, abs, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+
+	// skip[first body line to drop] = last body line to drop (1-based).
+	skip := make(map[int]int)
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		open := fset.Position(fd.Body.Lbrace).Line
+		close := fset.Position(fd.Body.Rbrace).Line
+		// Eliding a single line would save nothing over the marker.
+		if body := close - open - 1; body > maxLines+1 {
+			skip[open+1+maxLines] = close - 1
+		}
+	}
+	if len(skip) == 0 {
+		return src, true
+	}
+
+	lines := bytes.SplitAfter(src, []byte{'\n'})
+	var buf bytes.Buffer
+	buf.Grow(len(src))
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		if last, ok := skip[lineNo]; ok {
+			fmt.Fprintf(&buf, "\t// ... elided %d lines ...\n", last-lineNo+1)
+			i = last - 1
+			continue
+		}
+		buf.Write(lines[i])
+	}
+	return buf.Bytes(), true
+}
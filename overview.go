@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// treeEntry is a directory (or file, without children) of the -tree
+// overview.
+type treeEntry struct {
+	name     string
+	children map[string]*treeEntry
+}
+
+// writeTreeOverview writes an ASCII tree of the selected files (paths as in
+// their headers), so only directories holding selected files show up.
+func writeTreeOverview(w io.Writer, selectedRelSlash []string, opts buildOptions) {
+	root := &treeEntry{name: ".", children: make(map[string]*treeEntry)}
+	maxRun := 0
+	for _, relSlash := range selectedRelSlash {
+		p := displayPath(relSlash, opts)
+		maxRun = max(maxRun, maxRunByteInString(p, '`'))
+		cur := root
+		parts := strings.Split(p, "/")
+		for i, part := range parts {
+			next, ok := cur.children[part]
+			if !ok {
+				next = &treeEntry{name: part}
+				if i < len(parts)-1 {
+					next.children = make(map[string]*treeEntry)
+				}
+				cur.children[part] = next
+			}
+			cur = next
+		}
+	}
+	fence := fenceForContent(maxRun)
+
+	fmt.Fprint(w, "## Tree\n\n")
+	fmt.Fprintln(w, fence+"text")
+	fmt.Fprintln(w, ".")
+	writeTreeEntries(w, root, "")
+	fmt.Fprintln(w, fence)
+	fmt.Fprintln(w)
+}
+
+// writeTreeEntries writes dir's entries below prefix, directories first like
+// the TUI.
+func writeTreeEntries(w io.Writer, dir *treeEntry, prefix string) {
+	entries := make([]*treeEntry, 0, len(dir.children))
+	for _, e := range dir.children {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if (a.children != nil) != (b.children != nil) {
+			return a.children != nil
+		}
+		return a.name < b.name
+	})
+	for i, e := range entries {
+		branch, indent := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if e.children == nil {
+			fmt.Fprintln(w, prefix+branch+e.name)
+			continue
+		}
+		fmt.Fprintln(w, prefix+branch+e.name+"/")
+		writeTreeEntries(w, e, prefix+indent)
+	}
+}
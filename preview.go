@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Preview pane ("p"): the start of the file under the cursor, beside the
+// tree. Reads are bounded so huge files don't stall the UI, and cached per
+// node so moving around doesn't re-read on every frame.
+const (
+	previewMaxLines = 200
+	previewMaxBytes = 64 << 10
+)
+
+var previewTitleStyle = lipgloss.NewStyle().Faint(true)
+
+// refreshPreview loads the preview for the node under the cursor if it
+// isn't the one already shown.
+func (m *model) refreshPreview() {
+	if !m.previewing || len(m.vis) == 0 {
+		return
+	}
+	n := m.vis[m.cursor]
+	if n == m.previewNode {
+		return
+	}
+	m.previewNode = n
+	m.previewLines = previewLines(m.base, n, m.allowBinary)
+}
+
+// previewLines is what the pane shows for n: directory entries, the
+// `file` description of a binary, or the first lines of a text file.
+func previewLines(base string, n *node, allowBinary bool) []string {
+	if n.isDir {
+		var lines []string
+		for _, c := range n.children {
+			if c.isDir {
+				lines = append(lines, c.name+"/")
+			} else {
+				lines = append(lines, c.name)
+			}
+		}
+		return lines
+	}
+	if n.err != nil {
+		return []string{unwrapPathError(n.err).Error()}
+	}
+
+	abs := filepath.Join(base, n.relBase)
+	if allowBinary {
+		bin, err := isBinary(abs, defaultBinarySample)
+		if err != nil {
+			return []string{unwrapPathError(err).Error()}
+		}
+		if bin {
+			out, err := fileDescription(base, filepath.ToSlash(n.relBase))
+			if err != nil {
+				return []string{err.Error()}
+			}
+			return []string{string(out)}
+		}
+	}
+
+	f, err := os.Open(abs)
+	if err != nil {
+		return []string{unwrapPathError(err).Error()}
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(io.LimitReader(f, previewMaxBytes))
+	sc.Buffer(make([]byte, 0, 4096), previewMaxBytes)
+	for len(lines) < previewMaxLines && sc.Scan() {
+		lines = append(lines, sanitizePreview(sc.Text()))
+	}
+	return lines
+}
+
+// sanitizePreview expands tabs and drops control characters (escape
+// sequences included) so a line can't mess up the layout.
+func sanitizePreview(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// withPreview puts the preview for the cursor beside the rendered tree rows
+// (newline-terminated, like View writes them), splitting the terminal width
+// between them.
+func (m model) withPreview(tree string, rows int) string {
+	treeWidth := m.width / 2
+	paneWidth := m.width - treeWidth - 1 // one column for the separator
+
+	lines := []string{previewTitleStyle.Render(filepath.ToSlash(m.vis[m.cursor].relBase))}
+	lines = append(lines, m.previewLines...)
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+	pane := strings.Split(lipgloss.NewStyle().MaxWidth(paneWidth).Render(strings.Join(lines, "\n")), "\n")
+
+	// Fixed-width tree column, so the pane doesn't shift while scrolling.
+	treeRows := strings.Split(lipgloss.NewStyle().MaxWidth(treeWidth).Render(tree), "\n")
+	var b strings.Builder
+	for i := 0; i < rows; i++ {
+		row := ""
+		if i < len(treeRows) {
+			row = treeRows[i]
+		}
+		b.WriteString(row)
+		b.WriteString(strings.Repeat(" ", max(treeWidth-lipgloss.Width(row), 0)))
+		b.WriteString("│")
+		if i < len(pane) {
+			b.WriteString(pane[i])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
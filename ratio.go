@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tokenRatios is the bytes-per-token estimate (-token-ratio), optionally
+// per file type (-token-ratios md=3,go=3.5). Keys are extensions or
+// languageFor names. A nil *tokenRatios is the plain bytes/4 estimate.
+type tokenRatios struct {
+	def   float64
+	byKey map[string]float64
+}
+
+func parseTokenRatios(def float64, s string) (*tokenRatios, error) {
+	if def <= 0 {
+		return nil, fmt.Errorf("-token-ratio must be positive")
+	}
+	r := &tokenRatios{def: def, byKey: make(map[string]float64)}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		ratio, err := strconv.ParseFloat(v, 64)
+		if !ok || k == "" || err != nil || ratio <= 0 {
+			return nil, fmt.Errorf("-token-ratios: bad entry %q (want ext=ratio)", kv)
+		}
+		r.byKey[strings.ToLower(strings.TrimPrefix(k, "."))] = ratio
+	}
+	return r, nil
+}
+
+// forFile is the ratio for a file (slash path): by extension, then by
+// language.
+func (r *tokenRatios) forFile(name string) float64 {
+	if r == nil {
+		return 4
+	}
+	if ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")); ext != "" {
+		if ratio, ok := r.byKey[ext]; ok {
+			return ratio
+		}
+	}
+	if ratio, ok := r.byKey[languageFor(filepath.FromSlash(name))]; ok {
+		return ratio
+	}
+	return r.def
+}
+
+// base is the ratio for everything that isn't file content.
+func (r *tokenRatios) base() float64 {
+	if r == nil {
+		return 4
+	}
+	return r.def
+}
+
+// ratioTokens estimates size bytes at ratio bytes per token, rounding up.
+func ratioTokens(size int64, ratio float64) int64 {
+	return int64(math.Ceil(float64(size) / ratio))
+}
+
+// contentCounter is the counting writer for embedded content. copyFile sets
+// ratio for the file it is copying, so tokens follows the per-file ratios;
+// anything else written goes at the base ratio.
+type contentCounter struct {
+	countingWriter
+	ratios *tokenRatios
+	ratio  float64 // 0 = base
+	tokens float64
+}
+
+// copyFile is copyContent of the file at abs into dst, which ends up in c
+// (possibly through wrappers such as fenceSplitter), counted at the file's
+// ratio.
+func (c *contentCounter) copyFile(dst io.Writer, abs string, opts buildOptions) (garbled bool, err error) {
+	c.ratio = c.ratios.forFile(abs)
+	defer func() { c.ratio = 0 }()
+	return copyContent(dst, abs, opts)
+}
+
+// selectedTokens estimates the tokens of the selected files under n from
+// their sizes, at their per-file ratios like the build does.
+func selectedTokens(n *node, ratios *tokenRatios) int64 {
+	var tokens float64
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir {
+			if n.selected {
+				tokens += float64(n.size) / ratios.forFile(filepath.ToSlash(n.relBase))
+			}
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return int64(math.Ceil(tokens))
+}
+
+func (c *contentCounter) Write(p []byte) (int, error) {
+	n, err := c.countingWriter.Write(p)
+	ratio := c.ratio
+	if ratio == 0 {
+		ratio = c.ratios.base()
+	}
+	c.tokens += float64(n) / ratio
+	return n, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// pathRedactor replaces directory names with dir1, dir2, ... for -redact-paths
+// while keeping file names. The mapping is stable for the whole build. All
+// methods work on a nil receiver, which means "don't redact".
+type pathRedactor struct {
+	dirs map[string]string // original dir -> redacted dir
+	next int
+}
+
+func newPathRedactor() *pathRedactor {
+	return &pathRedactor{dirs: map[string]string{".": "."}}
+}
+
+// dir redacts a base-relative slash directory path.
+func (r *pathRedactor) dir(dirSlash string) string {
+	if r == nil {
+		return dirSlash
+	}
+	if red, ok := r.dirs[dirSlash]; ok {
+		return red
+	}
+	parent := r.dir(path.Dir(dirSlash))
+	r.next++
+	red := path.Join(parent, fmt.Sprintf("dir%d", r.next))
+	r.dirs[dirSlash] = red
+	return red
+}
+
+// file redacts the directory part of a base-relative slash file path.
+func (r *pathRedactor) file(relSlash string) string {
+	if r == nil {
+		return relSlash
+	}
+	return path.Join(r.dir(path.Dir(relSlash)), path.Base(relSlash))
+}
+
+// writeMapping prints "redacted = original" lines, sorted by redacted path.
+func (r *pathRedactor) writeMapping(w io.Writer) {
+	if r == nil {
+		return
+	}
+	lines := make([]string, 0, len(r.dirs))
+	for orig, red := range r.dirs {
+		if orig != "." {
+			lines = append(lines, red+" = "+orig)
+		}
+	}
+	sort.Strings(lines)
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
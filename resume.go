@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resumable builds (-resume): the markdown is written to a temp file next to
+// the target as usual, and after every finished file section the progress
+// is recorded in .mkctx/partial.json (with -o, in .<name>.partial.json next
+// to the target). If the build is interrupted, running the same command with
+// the same selection again truncates the temp file to the last checkpoint
+// and carries on from the next file.
+
+const progressFile = "partial.json"
+
+// progressPath is where the progress of a build into outPath is kept. The
+// default output names change every run, so those share one file.
+func progressPath(outPath string, opts buildOptions) string {
+	if opts.output != "" {
+		return filepath.Join(filepath.Dir(outPath), "."+filepath.Base(outPath)+"."+progressFile)
+	}
+	return filepath.Join(opts.outDir, progressFile)
+}
+
+// buildProgress is the content of partial.json.
+type buildProgress struct {
+	Key    string `json:"key"`    // hash of arguments and selection, see progressKey
+	Output string `json:"output"` // final markdown path
+	Temp   string `json:"temp"`   // the partial file
+
+	// As of the last checkpoint: how many selected files are done, the
+	// size of the partial file, and the counters for buildStats.
+	Done          int     `json:"done"`
+	Offset        int64   `json:"offset"`
+	Content       int64   `json:"content"`
+	ContentTokens float64 `json:"content_tokens"`
+	TotalTok      int64   `json:"total_tok,omitempty"`
+	ContentTok    int64   `json:"content_tok,omitempty"`
+}
+
+// progressKey identifies a build: the same command line and selection give
+// the same output, so a partial file of it can be continued.
+func progressKey(args, selectedRelSlash []string) string {
+	h := sha256.New()
+	io.WriteString(h, strings.Join(args, "\x00"))
+	io.WriteString(h, "\x00\x00")
+	io.WriteString(h, strings.Join(selectedRelSlash, "\x00"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readProgress returns the progress recorded at path, nil if there is none.
+// A progress file that can't be read (e.g. cut short by a crash) is
+// reported and ignored, so the build starts over.
+func readProgress(path string) *buildProgress {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	var p buildProgress
+	if err == nil {
+		err = json.Unmarshal(data, &p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mkctx: -resume: starting over, %s: %v\n", path, unwrapPathError(err))
+		return nil
+	}
+	return &p
+}
+
+// save writes p to path through a temp file, so an interrupted save leaves
+// the previous checkpoint in place.
+func (p *buildProgress) save(path string) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// writeMarkdownResumable is writeMarkdownFile for -resume. On failure the
+// partial file and its progress are kept for the next attempt.
+func writeMarkdownResumable(outPath, base string, selectedRelSlash []string, opts buildOptions) (buildStats, error) {
+	progPath := progressPath(outPath, opts)
+	prog := readProgress(progPath)
+	key := progressKey(os.Args[1:], selectedRelSlash)
+
+	var f *os.File
+	var err error
+	if prog != nil && prog.Key == key {
+		if f, err = os.OpenFile(prog.Temp, os.O_RDWR, 0); err == nil {
+			if err := f.Truncate(prog.Offset); err != nil {
+				f.Close()
+				return buildStats{}, err
+			}
+			if _, err := f.Seek(prog.Offset, io.SeekStart); err != nil {
+				f.Close()
+				return buildStats{}, err
+			}
+			outPath = prog.Output
+			fmt.Fprintf(os.Stderr, "mkctx: -resume: continuing %s after %d of %d files\n", outPath, prog.Done, len(selectedRelSlash))
+		} else {
+			prog = nil // the partial file is gone, start over
+		}
+	} else if prog != nil {
+		// Left over from a different build; it can't be continued now.
+		os.Remove(prog.Temp)
+		prog = nil
+	}
+	if prog == nil {
+		if f, err = os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".partial-*"); err != nil {
+			return buildStats{}, err
+		}
+		if err := f.Chmod(0o644); err != nil {
+			f.Close()
+			return buildStats{}, err
+		}
+		prog = &buildProgress{Key: key, Output: outPath, Temp: f.Name()}
+		if err := prog.save(progPath); err != nil {
+			f.Close()
+			return buildStats{}, err
+		}
+	}
+
+	opts.progress, opts.progressPath = prog, progPath
+	st, err := buildMarkdown(f, base, selectedRelSlash, opts)
+	if err != nil {
+		f.Close()
+		return buildStats{}, err
+	}
+	if err := f.Close(); err != nil {
+		return buildStats{}, err
+	}
+	if err := os.Rename(prog.Temp, outPath); err != nil {
+		return buildStats{}, err
+	}
+	if err := os.Remove(progPath); err != nil {
+		return buildStats{}, err
+	}
+
+	st.path, err = filepath.Abs(outPath)
+	if err != nil {
+		return buildStats{}, err
+	}
+	return st, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The review pane lists the selected files in output order and lets the user
+// move them around. Until something is moved, output follows -order.
+
+// reviewHelp is the footer help shown while the review pane is open.
+type reviewHelp struct{ k keyMap }
+
+func (h reviewHelp) ShortHelp() []key.Binding {
+	return []key.Binding{h.k.Up, h.k.Down, h.k.MoveUp, h.k.MoveDown, h.k.Review, h.k.Confirm}
+}
+
+func (h reviewHelp) FullHelp() [][]key.Binding {
+	return [][]key.Binding{h.ShortHelp()}
+}
+
+// selectedNodes returns selected file nodes in the default output order.
+func (m model) selectedNodes() []*node {
+	var out []*node
+	var walk func(*node)
+	walk = func(n *node) {
+		if !n.isDir && n.selected {
+			out = append(out, n)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(m.root)
+	sortNodes(out, m.orderBy)
+	return out
+}
+
+// orderedSelection returns the selected nodes in output order: the explicit
+// order first (skipping since-deselected files), then anything selected after
+// the order was last edited, in the default order.
+func (m model) orderedSelection() []*node {
+	if m.order == nil {
+		return m.selectedNodes()
+	}
+	seen := make(map[*node]bool, len(m.order))
+	var out []*node
+	for _, n := range m.order {
+		if n.selected {
+			out = append(out, n)
+			seen[n] = true
+		}
+	}
+	for _, n := range m.selectedNodes() {
+		if !seen[n] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func (m model) updateReview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Review), msg.String() == "esc":
+		m.reviewing = false
+		return m, nil
+
+	case key.Matches(msg, m.keys.Up):
+		if m.reviewCursor > 0 {
+			m.reviewCursor--
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keys.Down):
+		if m.reviewCursor < len(m.order)-1 {
+			m.reviewCursor++
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keys.MoveUp):
+		if i := m.reviewCursor; i > 0 {
+			m.order[i-1], m.order[i] = m.order[i], m.order[i-1]
+			m.reviewCursor--
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keys.MoveDown):
+		if i := m.reviewCursor; i < len(m.order)-1 {
+			m.order[i+1], m.order[i] = m.order[i], m.order[i+1]
+			m.reviewCursor++
+		}
+		return m, nil
+
+	case key.Matches(msg, m.keys.Confirm):
+		m.confirming = true
+		return m, nil
+	}
+	return m, nil
+}
+
+func (m model) viewReview() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "review | output order | selected=%d\n", len(m.order))
+
+	vh := m.viewportHeight()
+	start := 0
+	if m.reviewCursor >= vh {
+		start = m.reviewCursor - vh + 1
+	}
+	end := min(start+vh, len(m.order))
+
+	for i := start; i < end; i++ {
+		cur := " "
+		if i == m.reviewCursor {
+			cur = ">"
+		}
+		fmt.Fprintf(&b, "%s%3d. %s\n", cur, i+1, filepath.ToSlash(m.order[i].relBase))
+	}
+
+	b.WriteString(m.help.View(reviewHelp{m.keys}))
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Path arguments (`mkctx src/ docs/intro.md`): the tree holds only these
+// files and directories, unioned, instead of everything under the working
+// directory. Paths outside the git repository (or the working directory,
+// outside git) are walked on the filesystem and show up as ../ paths.
+
+// startRoot is one path argument, base-relative.
+type startRoot struct {
+	relSlash string
+	dir      bool
+}
+
+// resolveRoots turns path arguments (relative to the working directory)
+// into start roots, dropping any that lie inside another.
+func resolveRoots(base string, args []string) ([]startRoot, error) {
+	var roots []startRoot
+	for _, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		st, err := os.Stat(abs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, unwrapPathError(err))
+		}
+		rel, err := filepath.Rel(base, abs)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, startRoot{relSlash: filepath.ToSlash(rel), dir: st.IsDir()})
+	}
+
+	// Shortest first, so a root's ancestors are seen before it.
+	sort.Slice(roots, func(i, j int) bool { return len(roots[i].relSlash) < len(roots[j].relSlash) })
+	var kept []startRoot
+	for _, r := range roots {
+		covered := false
+		for _, k := range kept {
+			if k.dir && withinDir(k.relSlash, r.relSlash) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, r)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].relSlash < kept[j].relSlash })
+	return kept, nil
+}
+
+// withinDir reports whether relSlash is dir or lies under it.
+func withinDir(dir, relSlash string) bool {
+	if dir == "." {
+		return !isOutside(relSlash)
+	}
+	return relSlash == dir || strings.HasPrefix(relSlash, dir+"/")
+}
+
+// isOutside reports whether a base-relative slash path leaves base.
+func isOutside(relSlash string) bool {
+	return relSlash == ".." || strings.HasPrefix(relSlash, "../")
+}
+
+// rootsKey identifies a set of roots, e.g. for the last selection.
+func rootsKey(roots []startRoot) string {
+	keys := make([]string, len(roots))
+	for i, r := range roots {
+		keys[i] = r.relSlash
+	}
+	return strings.Join(keys, " ")
+}
+
+// listRoots lists the files (base-relative slash paths) under each root:
+// with git inside the repository, on the filesystem otherwise. A root that
+// yields nothing is reported on stderr.
+func listRoots(base string, inRepo bool, roots []startRoot) ([]string, error) {
+	var files []string
+	for _, r := range roots {
+		var listed []string
+		var err error
+		switch {
+		case inRepo && !isOutside(r.relSlash):
+			listed, err = gitListFiles(base, r.relSlash)
+		case !r.dir:
+			listed = []string{r.relSlash}
+		default:
+			// Only .gitignore files from the root down apply here.
+			listed, err = walkFiles(filepath.Join(base, filepath.FromSlash(r.relSlash)))
+			if r.relSlash != "." {
+				for i, relSlash := range listed {
+					listed[i] = r.relSlash + "/" + relSlash
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(listed) == 0 && len(roots) > 1 {
+			fmt.Fprintf(os.Stderr, "mkctx: %s: no files listed\n", r.relSlash)
+		}
+		files = append(files, listed...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// buildForest builds a tree whose synthetic root holds one subtree (or
+// file) per root, each labelled with its base-relative path. File roots
+// that were filtered out of files are left out.
+func buildForest(roots []startRoot, files []string) *node {
+	root := newDir(nil, ".", ".")
+	for _, r := range roots {
+		relOS := filepath.FromSlash(r.relSlash)
+		if !r.dir {
+			if slices.Contains(files, r.relSlash) {
+				root.addChild(newFile(root, r.relSlash, relOS))
+			}
+			continue
+		}
+		d := newDir(root, r.relSlash, relOS)
+		addFiles(d, r.relSlash, files)
+		root.addChild(d)
+	}
+	finalizeTree(root)
+	return root
+}
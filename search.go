@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var searchMatchStyle = lipgloss.NewStyle().Reverse(true)
+
+// searchMatch returns the index of the first visible node at or after from
+// (wrapping around) whose name contains query case-insensitively, or -1.
+func searchMatch(vis []*node, from int, query string) int {
+	if query == "" {
+		return -1
+	}
+	query = strings.ToLower(query)
+	for k := range vis {
+		i := (from + k) % len(vis)
+		if strings.Contains(strings.ToLower(vis[i].name), query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateSearch edits the search prompt and moves the cursor to the first
+// match as the query changes. Enter keeps the cursor, esc puts it back where
+// the search started. Other keys (e.g. arrows) are left to the tree.
+func (m model) updateSearch(msg tea.KeyMsg) (_ model, handled bool) {
+	switch msg.Type {
+	case tea.KeyRunes, tea.KeySpace:
+		m.searchQuery += string(msg.Runes)
+	case tea.KeyBackspace:
+		if r := []rune(m.searchQuery); len(r) > 0 {
+			m.searchQuery = string(r[:len(r)-1])
+		}
+	case tea.KeyEnter:
+		m.searching = false
+		m.searchQuery = ""
+		return m, true
+	case tea.KeyEsc:
+		m.searching = false
+		m.searchQuery = ""
+		m.cursor = m.searchFrom
+		m.ensureCursorVisible()
+		return m, true
+	default:
+		return m, false
+	}
+
+	m.cursor = m.searchFrom
+	if i := searchMatch(m.vis, m.searchFrom, m.searchQuery); i >= 0 {
+		m.cursor = i
+	}
+	m.ensureCursorVisible()
+	return m, true
+}
+
+// highlightMatch renders name with style, marking the first case-insensitive
+// occurrence of query.
+func highlightMatch(name, query string, style lipgloss.Style) string {
+	i := -1
+	lowerQuery := strings.ToLower(query)
+	if query != "" {
+		i = strings.Index(strings.ToLower(name), lowerQuery)
+	}
+	// Lowercasing can change byte lengths outside ASCII; don't highlight then.
+	if i < 0 || len(strings.ToLower(name)) != len(name) {
+		return style.Render(name)
+	}
+	// The match is as long as the lowercased query, not the query itself.
+	j := min(i+len(lowerQuery), len(name))
+	return style.Render(name[:i]) + searchMatchStyle.Render(name[i:j]) + style.Render(name[j:])
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A selection file is a JSON array of base-relative slash paths:
+//
+//	["cmd/mkctx/main.go", "README.md"]
+
+func readSelection(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var paths []string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	return paths
+}
+
+// readPathList reads newline-separated base-relative paths (-stdin), e.g.
+// from `git diff --name-only`. Blank lines are skipped.
+func readPathList(r io.Reader) ([]string, error) {
+	var paths []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		paths = append(paths, path.Clean(filepath.ToSlash(line)))
+	}
+	return paths, sc.Err()
+}
+
+// selectPaths selects the listed files in the tree and returns them in the
+// given -order. Paths that aren't selectable files of the tree are reported
+// on stderr and skipped.
+func selectPaths(root *node, base string, paths []string, order string) []string {
+	files := make(map[string]*node)
+	var index func(*node)
+	index = func(n *node) {
+		if !n.isDir && n.selectable() {
+			files[filepath.ToSlash(n.relBase)] = n
+		}
+		for _, c := range n.children {
+			index(c)
+		}
+	}
+	index(root)
+
+	var picked []*node
+	for _, p := range paths {
+		n, ok := files[p]
+		switch {
+		case ok && n.selected:
+			continue // listed twice
+		case ok:
+			n.selected = true
+			picked = append(picked, n)
+		default:
+			reason := "not in tree (ignored, binary or filtered out)"
+			if _, err := os.Stat(filepath.Join(base, filepath.FromSlash(p))); err != nil {
+				reason = unwrapPathError(err).Error()
+			}
+			fmt.Fprintf(os.Stderr, "mkctx: skipping %s: %s\n", p, reason)
+		}
+	}
+
+	sortNodes(picked, order)
+	out := make([]string, len(picked))
+	for i, n := range picked {
+		out[i] = filepath.ToSlash(n.relBase)
+	}
+	return out
+}
+
+// verifySelection checks every path of a selection against the current file
+// list and prints one line per problem. files are the selectable files,
+// binaries those dropped for being binary. Returns the number of problems.
+func verifySelection(base string, paths, files, binaries []string) int {
+	selectable := make(map[string]bool, len(files))
+	for _, f := range files {
+		selectable[f] = true
+	}
+	binary := make(map[string]bool, len(binaries))
+	for _, f := range binaries {
+		binary[f] = true
+	}
+
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+
+	problems := 0
+	for _, p := range sorted {
+		var reason string
+		switch {
+		case selectable[p]:
+			continue
+		case binary[p]:
+			reason = "binary"
+		default:
+			if _, err := os.Stat(filepath.Join(base, filepath.FromSlash(p))); err != nil {
+				reason = "missing"
+			} else {
+				reason = "ignored"
+			}
+		}
+		fmt.Printf("%s: %s\n", reason, p)
+		problems++
+	}
+	if problems == 0 {
+		fmt.Printf("ok: %d paths\n", len(paths))
+	}
+	return problems
+}
+
+// diffSelections prints the paths added and removed going from old to new.
+func diffSelections(old, new []string) {
+	inOld := make(map[string]bool, len(old))
+	for _, p := range old {
+		inOld[p] = true
+	}
+	inNew := make(map[string]bool, len(new))
+	for _, p := range new {
+		inNew[p] = true
+	}
+
+	var added, removed []string
+	for p := range inNew {
+		if !inOld[p] {
+			added = append(added, p)
+		}
+	}
+	for p := range inOld {
+		if !inNew[p] {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	for _, p := range added {
+		fmt.Printf("added: %s\n", p)
+	}
+	for _, p := range removed {
+		fmt.Printf("removed: %s\n", p)
+	}
+}
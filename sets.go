@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Selection sets: the active set lives in the nodes' selected flags (so the
+// rest of the UI doesn't care), the others are parked in model.sets.
+
+// savedSet is a parked selection set.
+type savedSet struct {
+	nodes    []*node // output order
+	explicit bool    // order was edited in the review pane
+}
+
+// switchSet parks the active selection and activates set k.
+func (m *model) switchSet(k int) {
+	if k == m.activeSet {
+		return
+	}
+	if m.sets == nil {
+		m.sets = make(map[int]savedSet)
+	}
+	m.sets[m.activeSet] = savedSet{nodes: m.orderedSelection(), explicit: m.order != nil}
+
+	clearSelection(m.root)
+	next := m.sets[k]
+	for _, n := range next.nodes {
+		n.selected = true
+	}
+	m.order = nil
+	if next.explicit {
+		m.order = append([]*node(nil), next.nodes...)
+	}
+	m.selectedCount = len(next.nodes)
+	m.activeSet = k
+	delete(m.sets, k)
+}
+
+// selectionSets returns every non-empty set's files in output order.
+func (m model) selectionSets() map[int][]string {
+	out := make(map[int][]string)
+	if files := m.selectedFiles(); len(files) > 0 {
+		out[m.activeSet] = files
+	}
+	for k, s := range m.sets {
+		if len(s.nodes) == 0 {
+			continue
+		}
+		files := make([]string, len(s.nodes))
+		for i, n := range s.nodes {
+			files[i] = filepath.ToSlash(n.relBase)
+		}
+		out[k] = files
+	}
+	return out
+}
+
+// setsStatus renders the set tabs for the status line, e.g. "sets [1] 3 4".
+func (m model) setsStatus() string {
+	keys := []int{m.activeSet}
+	for k, s := range m.sets {
+		if len(s.nodes) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+
+	out := "sets"
+	for _, k := range keys {
+		if k == m.activeSet {
+			out += fmt.Sprintf(" [%d]", k)
+		} else {
+			out += fmt.Sprintf(" %d", k)
+		}
+	}
+	return out
+}
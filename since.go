@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Delta contexts (-since prev.md): files whose hash matches the one in a
+// previous context's headers are left out, so only what changed since gets
+// embedded. The hashes come from -hashes headers, which -since turns on so
+// each delta can be the base of the next. With -ignore-whitespace the
+// headers carry sha256-ws: hashes of whitespace-collapsed content instead,
+// and reformatting alone doesn't count as a change.
+
+// headerHash matches one "path (sha256:..., ...)" entry of a section header;
+// merged sections list several, separated by ", ".
+var headerHash = regexp.MustCompile(`(?:^|, )(.+?) \((sha256(?:-ws)?:[0-9a-f]{64})(?:, [^)]*)?\)`)
+
+// readSinceHashes returns the hash notes ("sha256:..." or "sha256-ws:...")
+// by path from the section headers of a context written with -hashes.
+// Headers with -redact-paths or -header-base paths won't match the tree.
+func readSinceHashes(mdPath string) (map[string]string, error) {
+	f, err := os.Open(mdPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		var title string
+		switch {
+		case strings.HasPrefix(line, "## "):
+			title = strings.TrimPrefix(line, "## ")
+		case strings.HasPrefix(line, "<summary>"):
+			title = html.UnescapeString(strings.TrimPrefix(line, "<summary>"))
+		default:
+			continue
+		}
+		for _, m := range headerHash.FindAllStringSubmatch(title, -1) {
+			hashes[m[1]] = m[2]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("%s: no sha256 hashes in its headers (was it written with -hashes?)", mdPath)
+	}
+	return hashes, nil
+}
+
+// dropUnchanged removes files whose content still hashes to their note in
+// prev, computing each hash the way the note was. It returns how many files
+// it dropped and how many could only be compared byte for byte because
+// prev has a plain sha256 where ignoreWS wanted sha256-ws. Unreadable files
+// are kept; filterBinaries reports those.
+func dropUnchanged(base string, files []string, prev map[string]string, ignoreWS bool) (kept []string, dropped, raw int) {
+	kept = files[:0:0]
+	for _, relSlash := range files {
+		note, ok := prev[relSlash]
+		if !ok {
+			kept = append(kept, relSlash)
+			continue
+		}
+		sum, err := hashNote(filepath.Join(base, filepath.FromSlash(relSlash)), strings.HasPrefix(note, "sha256-ws:"))
+		if err != nil {
+			kept = append(kept, relSlash)
+			continue
+		}
+		if ignoreWS && strings.HasPrefix(note, "sha256:") {
+			raw++
+		}
+		if sum == note {
+			dropped++
+			continue
+		}
+		kept = append(kept, relSlash)
+	}
+	return kept, dropped, raw
+}
+
+// hashNote is a file's hash as written in headers: "sha256:" over the raw
+// bytes, or "sha256-ws:" over the content with every run of whitespace
+// collapsed into one space (and none at either end).
+func hashNote(abs string, collapseWS bool) (string, error) {
+	if !collapseWS {
+		sum, err := hashFile(abs)
+		if err != nil {
+			return "", err
+		}
+		return "sha256:" + sum, nil
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for i, field := range bytes.Fields(data) {
+		if i > 0 {
+			h.Write([]byte{' '})
+		}
+		h.Write(field)
+	}
+	return "sha256-ws:" + hex.EncodeToString(h.Sum(nil)), nil
+}
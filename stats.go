@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// statsLargest is how many of the biggest files -stats lists.
+const statsLargest = 5
+
+// writeStats writes the -stats section: totals, a per-language breakdown and
+// the largest files of the selection, as markdown tables. Sizes and lines
+// are of the files on disk, before any transforms.
+func writeStats(w io.Writer, base string, selectedRelSlash []string, opts buildOptions) error {
+	type fileStat struct {
+		relSlash string
+		size     int64
+	}
+	type langStat struct {
+		files int
+		size  int64
+		lines int64
+	}
+	files := make([]fileStat, 0, len(selectedRelSlash))
+	langs := make(map[string]*langStat)
+	var total langStat
+	for _, relSlash := range selectedRelSlash {
+		abs := filepath.Join(base, filepath.FromSlash(relSlash))
+		st, err := os.Stat(abs)
+		if err != nil {
+			return err
+		}
+		lines, err := countLines(abs)
+		if err != nil {
+			return err
+		}
+		lang := languageFor(filepath.FromSlash(relSlash))
+		if lang == "" {
+			lang = shebangLanguage(abs)
+		}
+		if lang == "" {
+			lang = "other"
+		}
+		ls := langs[lang]
+		if ls == nil {
+			ls = &langStat{}
+			langs[lang] = ls
+		}
+		for _, s := range []*langStat{ls, &total} {
+			s.files++
+			s.size += st.Size()
+			s.lines += lines
+		}
+		files = append(files, fileStat{relSlash, st.Size()})
+	}
+
+	fmt.Fprint(w, "## Stats\n\n")
+	fmt.Fprintf(w, "| files | size | lines |\n| ---: | ---: | ---: |\n| %d | %s | %d |\n\n", total.files, humanBytes(total.size), total.lines)
+
+	names := make([]string, 0, len(langs))
+	for lang := range langs {
+		names = append(names, lang)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if langs[names[i]].size != langs[names[j]].size {
+			return langs[names[i]].size > langs[names[j]].size
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprint(w, "| language | files | size | lines |\n| --- | ---: | ---: | ---: |\n")
+	for _, lang := range names {
+		ls := langs[lang]
+		fmt.Fprintf(w, "| %s | %d | %s | %d |\n", lang, ls.files, humanBytes(ls.size), ls.lines)
+	}
+	fmt.Fprintln(w)
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].size > files[j].size })
+	fmt.Fprint(w, "| largest files | size |\n| --- | ---: |\n")
+	for _, f := range files[:min(len(files), statsLargest)] {
+		fmt.Fprintf(w, "| %s | %s |\n", strings.ReplaceAll(displayPath(f.relSlash, opts), "|", `\|`), humanBytes(f.size))
+	}
+	fmt.Fprintln(w)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// With -tokenizer, token counts come from a real BPE encoding instead of
+// byte ratios. The encoding's ranks are downloaded on first use and cached
+// (see TIKTOKEN_CACHE_DIR); when that fails, e.g. offline, main warns and
+// goes on with the ratios.
+
+var knownEncodings = []string{
+	tiktoken.MODEL_O200K_BASE,
+	tiktoken.MODEL_CL100K_BASE,
+	tiktoken.MODEL_P50K_BASE,
+	tiktoken.MODEL_P50K_EDIT,
+	tiktoken.MODEL_R50K_BASE,
+}
+
+// checkEncodingName fails for names tiktoken doesn't know, so typos are usage
+// errors rather than something to fall back from.
+func checkEncodingName(name string) error {
+	if !slices.Contains(knownEncodings, name) {
+		return fmt.Errorf("unknown encoding %q (known: %s)", name, strings.Join(knownEncodings, ", "))
+	}
+	return nil
+}
+
+func loadTokenizer(name string) (*tiktoken.Tiktoken, error) {
+	return tiktoken.GetEncoding(name)
+}
+
+// tokenChunk is how much tokenCounter buffers before encoding. Chunks end
+// at a newline, which pretty much never splits a token; input without
+// newlines is counted anyway once tokenBufMax is buffered.
+const (
+	tokenChunk  = 64 << 10
+	tokenBufMax = 1 << 20
+)
+
+// tokenCounter counts the tokens of everything written to it.
+type tokenCounter struct {
+	enc *tiktoken.Tiktoken
+	buf []byte
+	n   int64
+}
+
+func (t *tokenCounter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) >= tokenChunk {
+		if i := bytes.LastIndexByte(t.buf, '\n'); i >= 0 {
+			t.count(t.buf[:i+1])
+			t.buf = append(t.buf[:0], t.buf[i+1:]...)
+		} else if len(t.buf) >= tokenBufMax {
+			t.count(t.buf)
+			t.buf = t.buf[:0]
+		}
+	}
+	return len(p), nil
+}
+
+// Flush counts what is still buffered; call it after the last write.
+func (t *tokenCounter) Flush() error {
+	t.count(t.buf)
+	t.buf = t.buf[:0]
+	return nil
+}
+
+func (t *tokenCounter) count(p []byte) {
+	if len(p) > 0 {
+		t.n += int64(len(t.enc.EncodeOrdinary(string(p))))
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Content transforms are io.Writer filters stacked in front of the output.
+// The same stack is used twice per file: once into a maxRunWriter to size the
+// fence, once into the real output. That way the fence always matches the
+// bytes that are actually embedded.
+
+// openContent returns the source bytes for a file: the file itself, or a
+// generated replacement (e.g. a Go outline) when an option asks for one.
+// git-LFS pointers are always replaced by a note, see lfsNote.
+func openContent(abs string, opts buildOptions) (io.ReadCloser, error) {
+	if note, ok := lfsNote(abs); ok {
+		return io.NopCloser(bytes.NewReader(note)), nil
+	}
+	if languageFor(abs) == "go" {
+		switch {
+		case opts.outline:
+			if out, ok := goOutline(abs); ok {
+				return io.NopCloser(bytes.NewReader(out)), nil
+			}
+		case opts.elideLong > 0:
+			if out, ok := goElide(abs, opts.elideLong); ok {
+				return io.NopCloser(bytes.NewReader(out)), nil
+			}
+		}
+	}
+	return os.Open(abs)
+}
+
+// copyContent streams the (transformed) contents of the file at abs into dst.
+// With opts.checkEncoding it also reports whether the raw content looked
+// garbled, see encodingChecker.
+func copyContent(dst io.Writer, abs string, opts buildOptions) (garbled bool, err error) {
+	marked := opts.markers && hasMarkers(abs)
+	if marked {
+		// The marked regions are what the author wants shown, verbatim.
+		opts.outline, opts.elideLong = false, 0
+	}
+	in, err := openContent(abs, opts)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	// Build the chain inside out; flushers are kept outermost first.
+	w := dst
+	var flushers []flusher
+	var src *sourceLines
+	if opts.numbers {
+		lines, err := countLines(abs)
+		if err != nil {
+			return false, err
+		}
+		src = &sourceLines{start: true}
+		w = &lineNumberer{w: w, src: src, width: len(strconv.FormatInt(max(lines, 1), 10)), start: true}
+	}
+	if opts.collapseBlanks {
+		cb := &blankCollapser{w: w}
+		flushers = append([]flusher{cb}, flushers...)
+		w = cb
+	}
+	if opts.stripANSI {
+		w = &ansiStripper{w: w}
+	}
+	var ec *encodingChecker
+	if opts.checkEncoding {
+		ec = &encodingChecker{w: w}
+		w = ec
+	}
+	if marked {
+		mf := &markerFilter{w: w}
+		flushers = append([]flusher{mf}, flushers...)
+		w = mf
+	}
+	if src != nil {
+		src.w = w
+		w = src
+	}
+
+	if _, err := io.Copy(w, skipBOM(in)); err != nil {
+		return false, err
+	}
+	for _, f := range flushers {
+		if err := f.Flush(); err != nil {
+			return false, err
+		}
+	}
+	return ec != nil && ec.garbled(), nil
+}
+
+// flusher is implemented by filters that hold back data (e.g. a partial
+// line) until they see more input or are flushed at end of file.
+type flusher interface {
+	Flush() error
+}
+
+// maxRunInContent returns the longest backtick run of the transformed content.
+func maxRunInContent(abs string, opts buildOptions) (int, error) {
+	mw := &maxRunWriter{b: '`'}
+	if _, err := copyContent(mw, abs, opts); err != nil {
+		return 0, err
+	}
+	return mw.max, nil
+}
+
+// maxRunWriter discards everything and remembers the longest run of b.
+type maxRunWriter struct {
+	b   byte
+	run int
+	max int
+}
+
+func (m *maxRunWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c == m.b {
+			m.run++
+			if m.run > m.max {
+				m.max = m.run
+			}
+		} else {
+			m.run = 0
+		}
+	}
+	return len(p), nil
+}
+
+type ansiState int
+
+const (
+	ansiText      ansiState = iota
+	ansiEsc                 // after ESC
+	ansiInter               // ESC + intermediate bytes, waiting for final
+	ansiCSI                 // ESC [ ... waiting for final byte
+	ansiString              // OSC/DCS/etc, terminated by BEL or ST
+	ansiStringEsc           // ESC seen inside a string, maybe ST
+)
+
+// ansiStripper removes ANSI escape sequences (CSI, OSC, DCS and plain
+// two-byte escapes). State is kept across writes, so sequences split between
+// buffers are handled.
+type ansiStripper struct {
+	w     io.Writer
+	state ansiState
+	buf   []byte
+}
+
+func (s *ansiStripper) Write(p []byte) (int, error) {
+	out := s.buf[:0]
+	for _, c := range p {
+		switch s.state {
+		case ansiText:
+			if c == 0x1b {
+				s.state = ansiEsc
+				continue
+			}
+			out = append(out, c)
+
+		case ansiEsc:
+			switch {
+			case c == '[':
+				s.state = ansiCSI
+			case c == ']' || c == 'P' || c == 'X' || c == '^' || c == '_':
+				s.state = ansiString
+			case c >= 0x20 && c <= 0x2f:
+				s.state = ansiInter
+			default:
+				s.state = ansiText
+			}
+
+		case ansiInter:
+			if c >= 0x30 && c <= 0x7e {
+				s.state = ansiText
+			}
+
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				s.state = ansiText
+			}
+
+		case ansiString:
+			switch c {
+			case 0x07:
+				s.state = ansiText
+			case 0x1b:
+				s.state = ansiStringEsc
+			}
+
+		case ansiStringEsc:
+			if c == '\\' {
+				s.state = ansiText
+			} else {
+				s.state = ansiString
+			}
+		}
+	}
+	s.buf = out
+	if _, err := s.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// blankCollapser squeezes runs of blank (whitespace-only) lines into a
+// single blank line.
+type blankCollapser struct {
+	w      io.Writer
+	line   []byte
+	blanks int
+}
+
+func (b *blankCollapser) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			b.line = append(b.line, p...)
+			break
+		}
+		b.line = append(b.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := b.emitLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (b *blankCollapser) emitLine() error {
+	line := b.line
+	b.line = b.line[:0]
+	if len(bytes.Trim(line, " \t\r\n")) == 0 {
+		b.blanks++
+		if b.blanks > 1 {
+			return nil
+		}
+	} else {
+		b.blanks = 0
+	}
+	_, err := b.w.Write(line)
+	return err
+}
+
+func (b *blankCollapser) Flush() error {
+	if len(b.line) == 0 {
+		return nil
+	}
+	_, err := b.w.Write(b.line)
+	b.line = b.line[:0]
+	return err
+}
+
+// encodingChecker passes data through unchanged, noting whether it holds
+// invalid UTF-8 or U+FFFD, either of which shows up as the replacement
+// character once embedded.
+type encodingChecker struct {
+	w     io.Writer
+	tail  []byte // incomplete sequence at the end of the previous write
+	found bool
+}
+
+func (e *encodingChecker) Write(p []byte) (int, error) {
+	if !e.found {
+		buf := append(e.tail, p...)
+		e.tail = e.tail[:0]
+		for i := 0; i < len(buf); {
+			r, size := utf8.DecodeRune(buf[i:])
+			if r == utf8.RuneError && size <= 1 && !utf8.FullRune(buf[i:]) {
+				e.tail = append(e.tail, buf[i:]...)
+				break
+			}
+			if r == utf8.RuneError {
+				e.found = true
+				break
+			}
+			i += size
+		}
+	}
+	return e.w.Write(p)
+}
+
+// garbled reports the verdict; call it after the last write.
+func (e *encodingChecker) garbled() bool {
+	return e.found || len(e.tail) > 0
+}
+
+// Marker comments for -markers: lines that are just such a comment are
+// dropped, and of a file that has any only the lines between start and end
+// are embedded. A marker mentioned in code or prose doesn't count.
+var (
+	markerStart = []byte("mkctx:start")
+	markerEnd   = []byte("mkctx:end")
+)
+
+// isMarker reports whether line is a comment (//, #, -- or /* */) whose text
+// is exactly marker.
+func isMarker(line, marker []byte) bool {
+	line = bytes.TrimSpace(line)
+	switch {
+	case bytes.HasPrefix(line, []byte("//")), bytes.HasPrefix(line, []byte("--")):
+		line = line[2:]
+	case bytes.HasPrefix(line, []byte("#")):
+		line = line[1:]
+	case bytes.HasPrefix(line, []byte("/*")):
+		line = bytes.TrimSuffix(line[2:], []byte("*/"))
+	default:
+		return false
+	}
+	return bytes.Equal(bytes.TrimSpace(line), marker)
+}
+
+// hasMarkers reports whether the file contains a start marker. Read errors
+// count as no; opening the file for the copy reports them.
+func hasMarkers(abs string) bool {
+	f, err := os.Open(abs)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for sc.Scan() {
+		if isMarker(sc.Bytes(), markerStart) {
+			return true
+		}
+	}
+	return false
+}
+
+// markerFilter passes through only the lines between a start and an end
+// marker line. Several regions are fine; a start without an end runs to the
+// end of the file.
+type markerFilter struct {
+	w      io.Writer
+	line   []byte
+	inside bool
+}
+
+func (m *markerFilter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			m.line = append(m.line, p...)
+			break
+		}
+		m.line = append(m.line, p[:i+1]...)
+		p = p[i+1:]
+		if err := m.emitLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (m *markerFilter) emitLine() error {
+	line := m.line
+	m.line = m.line[:0]
+	switch {
+	case isMarker(line, markerStart):
+		m.inside = true
+		return nil
+	case isMarker(line, markerEnd):
+		m.inside = false
+		return nil
+	case !m.inside:
+		return nil
+	}
+	_, err := m.w.Write(line)
+	return err
+}
+
+func (m *markerFilter) Flush() error {
+	if len(m.line) == 0 {
+		return nil
+	}
+	return m.emitLine()
+}
+
+// sourceLines sits first in the chain for -numbers. It passes the content on
+// one line at a time and keeps n at the number of the line being passed, so
+// lineNumberer at the end of the chain numbers lines as in the file, even
+// after filters such as -markers dropped some. The line based filters emit a
+// line while they are handed its newline, or at Flush for the last one.
+type sourceLines struct {
+	w     io.Writer
+	n     int
+	start bool // next byte begins a line
+}
+
+func (s *sourceLines) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if s.start {
+			s.n++
+			s.start = false
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+			s.start = true
+		}
+		if _, err := s.w.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// lineNumberer prefixes every line with its right-aligned source line number
+// from src (-numbers). It sits last in the chain; the width comes from the
+// file's line count.
+type lineNumberer struct {
+	w     io.Writer
+	src   *sourceLines
+	width int
+	start bool // next byte begins a line
+}
+
+func (l *lineNumberer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if l.start {
+			if _, err := fmt.Fprintf(l.w, "%*d | ", l.width, l.src.n); err != nil {
+				return 0, err
+			}
+			l.start = false
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+			l.start = true
+		}
+		if _, err := l.w.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// fenceSplitter cuts a file's embedded content into fenced blocks of at most
+// every lines (-split-at): after that many lines, if more follows, it closes
+// the fence and reopens it under a continuation note. Structure goes to w,
+// content to content, like in writeFileSections.
+type fenceSplitter struct {
+	w, content  io.Writer
+	every       int
+	fence, info string // closing fence; opening one is fence+info
+	label       string // the file's path as shown in its header
+
+	lines int // in the current block
+	total int
+}
+
+func (s *fenceSplitter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if s.lines == s.every {
+			fmt.Fprintf(s.w, "\n%s\n\n(%s continued from line %d)\n\n%s\n", s.fence, s.label, s.total+1, s.fence+s.info)
+			s.lines = 0
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+			s.lines++
+			s.total++
+		}
+		if _, err := s.content.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// treeJSON is the -dump-tree / -apply-tree exchange format. Paths are
+// base-relative with '/' separators; selected is only meaningful on files.
+// Directories cut off by -depth have no children, just the number of files
+// under them.
+type treeJSON struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Dir      bool        `json:"dir,omitempty"`
+	Selected bool        `json:"selected"`
+	Files    int         `json:"files,omitempty"`
+	Children []*treeJSON `json:"children,omitempty"`
+}
+
+func toTreeJSON(n *node, maxDepth int) *treeJSON {
+	t := &treeJSON{
+		Name:     n.name,
+		Path:     filepath.ToSlash(n.relBase),
+		Dir:      n.isDir,
+		Selected: n.selected,
+	}
+	if cutOff(n, maxDepth) {
+		t.Files = dirSelection(n)[n][0]
+		return t
+	}
+	for _, c := range n.children {
+		t.Children = append(t.Children, toTreeJSON(c, maxDepth))
+	}
+	return t
+}
+
+// cutOff reports whether -depth maxDepth (0 = unlimited) stops at directory
+// n, summarizing what is below it.
+func cutOff(n *node, maxDepth int) bool {
+	return maxDepth > 0 && n.isDir && n.depth >= maxDepth
+}
+
+func dumpTreeJSON(w io.Writer, root *node, maxDepth int) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(toTreeJSON(root, maxDepth))
+}
+
+// listTree prints the tree as indented text (-list), directories with a
+// trailing slash and, below -depth, as "dir/ (N files)".
+func listTree(w io.Writer, root *node, maxDepth int) error {
+	bw := bufio.NewWriter(w)
+	var walk func(*node)
+	walk = func(n *node) {
+		indent := strings.Repeat("  ", n.depth)
+		switch {
+		case !n.isDir:
+			fmt.Fprintf(bw, "%s%s\n", indent, n.name)
+			return
+		case cutOff(n, maxDepth):
+			fmt.Fprintf(bw, "%s%s/ (%d files)\n", indent, n.name, dirSelection(n)[n][0])
+			return
+		}
+		fmt.Fprintf(bw, "%s%s/\n", indent, n.name)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(root)
+	return bw.Flush()
+}
+
+func readTreeJSON(path string) *treeJSON {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	var t treeJSON
+	if err := json.Unmarshal(data, &t); err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	return &t
+}
+
+// applyTreeJSON marks the files selected in t on root and returns the
+// selection in the given -order. Selected paths that aren't in the tree (deleted,
+// ignored, binary without -b) are reported on stderr and skipped.
+func applyTreeJSON(root *node, t *treeJSON, order string) []string {
+	files := make(map[string]*node)
+	var index func(*node)
+	index = func(n *node) {
+		if !n.isDir {
+			files[filepath.ToSlash(n.relBase)] = n
+		}
+		for _, c := range n.children {
+			index(c)
+		}
+	}
+	index(root)
+
+	var picked []*node
+	var walk func(*treeJSON)
+	walk = func(t *treeJSON) {
+		if !t.Dir && t.Selected {
+			if n, ok := files[t.Path]; ok {
+				n.selected = true
+				picked = append(picked, n)
+			} else {
+				fmt.Fprintf(os.Stderr, "mkctx: skipping %s: not in tree\n", t.Path)
+			}
+		}
+		for _, c := range t.Children {
+			walk(c)
+		}
+	}
+	walk(t)
+
+	sortNodes(picked, order)
+	out := make([]string, len(picked))
+	for i, n := range picked {
+		out[i] = filepath.ToSlash(n.relBase)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package main
+
+import "strings"
+
+// defaultVendorDirs are directory names holding third-party code that
+// -no-vendor keeps out of the tree.
+const defaultVendorDirs = "vendor,node_modules,third_party,.venv,Godeps"
+
+// dropVendored removes files that live under a directory named like one of
+// dirs, at any depth.
+func dropVendored(files []string, dirs string) []string {
+	skip := vendorNames(dirs)
+	if len(skip) == 0 {
+		return files
+	}
+
+	kept := files[:0:0]
+outer:
+	for _, relSlash := range files {
+		parts := strings.Split(relSlash, "/")
+		for _, p := range parts[:len(parts)-1] {
+			if skip[p] {
+				continue outer
+			}
+		}
+		kept = append(kept, relSlash)
+	}
+	return kept
+}
+
+// vendorNames parses the comma-separated -vendor-dirs list.
+func vendorNames(dirs string) map[string]bool {
+	names := make(map[string]bool)
+	for _, d := range strings.Split(dirs, ",") {
+		if d = strings.Trim(strings.TrimSpace(d), "/"); d != "" {
+			names[d] = true
+		}
+	}
+	return names
+}
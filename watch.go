@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces bursts of events (editors often write, rename and
+// chmod in quick succession) into a single rebuild.
+const watchDebounce = 250 * time.Millisecond
+
+// watchAndRebuild regenerates the context file whenever one of the selected
+// files changes on disk. It blocks until interrupted (Ctrl+C / SIGTERM).
+// A failed rebuild is reported and the watch goes on.
+//
+// Parent directories are watched instead of the files themselves, so that
+// atomic "write temp + rename" saves keep being noticed.
+func watchAndRebuild(base string, selectedRelSlash []string, opts buildOptions) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	watched := make(map[string]bool, len(selectedRelSlash))
+	dirs := make(map[string]bool)
+	for _, relSlash := range selectedRelSlash {
+		abs := filepath.Join(base, filepath.FromSlash(relSlash))
+		watched[abs] = true
+		dir := filepath.Dir(abs)
+		if dirs[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			return fmt.Errorf("watch %s: %v", dir, err)
+		}
+		dirs[dir] = true
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-sig:
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if !watched[filepath.Clean(ev.Name)] {
+				continue
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+
+		case <-timer.C:
+			// Files may be briefly missing mid-save; wait for the next event.
+			if !allExist(base, selectedRelSlash) {
+				continue
+			}
+			if err := build(base, selectedRelSlash, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "mkctx: %v\n", err)
+			}
+		}
+	}
+}
+
+func allExist(base string, relSlash []string) bool {
+	for _, rel := range relSlash {
+		if _, err := os.Stat(filepath.Join(base, filepath.FromSlash(rel))); err != nil {
+			return false
+		}
+	}
+	return true
+}
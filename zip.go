@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// zipManifest is written as manifest.json into -zip archives.
+type zipManifest struct {
+	Created string   `json:"created"`
+	Context string   `json:"context"` // name of the markdown file in the archive
+	Files   []string `json:"files"`   // output order
+}
+
+// writeZip bundles the selected files (at their base-relative paths, as
+// redacted in the markdown with -redact-paths), the generated markdown and a
+// manifest. Returns the archive's absolute path and size.
+func writeZip(zipPath, base string, selectedRelSlash []string, mdPath string, redact *pathRedactor) (string, int64, error) {
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	mdName := filepath.Base(mdPath)
+	if err := addZipFile(zw, mdName, mdPath); err != nil {
+		return "", 0, err
+	}
+	names := make([]string, len(selectedRelSlash))
+	for i, relSlash := range selectedRelSlash {
+		names[i] = zipName(redact.file(relSlash))
+		if err := addZipFile(zw, names[i], filepath.Join(base, filepath.FromSlash(relSlash))); err != nil {
+			return "", 0, err
+		}
+	}
+
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return "", 0, err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(zipManifest{
+		Created: time.Now().Format(time.RFC3339),
+		Context: mdName,
+		Files:   names,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+
+	st, err := os.Stat(zipPath)
+	if err != nil {
+		return "", 0, err
+	}
+	abs, err := filepath.Abs(zipPath)
+	if err != nil {
+		return "", 0, err
+	}
+	return abs, st.Size(), nil
+}
+
+// zipName keeps files of roots outside the repository inside the archive:
+// every leading ".." becomes "_outside", so nothing extracts above the
+// target directory.
+func zipName(relSlash string) string {
+	var prefix string
+	for isOutside(relSlash) {
+		prefix += "_outside/"
+		relSlash = strings.TrimPrefix(strings.TrimPrefix(relSlash, ".."), "/")
+	}
+	return prefix + relSlash
+}
+
+func addZipFile(zw *zip.Writer, name, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(st)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// withSuffix inserts suffix before the extension: "ctx.zip" -> "ctx-set2.zip".
+func withSuffix(path, suffix string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + suffix + ext
+}